@@ -0,0 +1,99 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+)
+
+// BinChange is one bin of a spectrum comparison
+type BinChange struct {
+	Bin        int      `json:"bin"`
+	Frequency  float64  `json:"frequency"`
+	Reference  float64  `json:"reference"`
+	Reading    float64  `json:"reading"`
+	Difference float64  `json:"difference"`
+	Ratio      *float64 `json:"ratio"` // nil where the reference bin is zero
+}
+
+// SpectrumComparison holds the per-bin change from a reference spectrum to a
+// reading on their common frequency grid
+type SpectrumComparison struct {
+	BinWidth   float64     `json:"bin_width"`
+	Lines      int         `json:"lines"`
+	Difference []float64   `json:"difference"`
+	Ratio      []*float64  `json:"ratio"`
+	TopChanges []BinChange `json:"top_changes"`
+}
+
+// ToPeak converts amplitudes in the given scaling to peak amplitudes
+func ToPeak(amplitudes []float64, scaling string) []float64 {
+	factor := 1.0
+	switch scaling {
+	case models.ScalingRMS:
+		factor = math.Sqrt2
+	case models.ScalingPeakToPeak:
+		factor = 0.5
+	}
+	out := make([]float64, len(amplitudes))
+	for k, a := range amplitudes {
+		out[k] = a * factor
+	}
+	return out
+}
+
+// CommonGrid resamples two spectra onto the coarser of their bin widths, up to
+// the lower of their highest frequencies. Spectra already on the same grid are
+// returned unchanged.
+func CommonGrid(a, b Spectrum) (Spectrum, Spectrum) {
+	if a.BinWidth == b.BinWidth && len(a.Amplitudes) == len(b.Amplitudes) {
+		return a, b
+	}
+
+	binWidth := math.Max(a.BinWidth, b.BinWidth)
+	top := math.Min(a.BinWidth*float64(len(a.Amplitudes)-1), b.BinWidth*float64(len(b.Amplitudes)-1))
+	lines := int(top/binWidth+1e-9) + 1
+	return a.Resample(binWidth, lines), b.Resample(binWidth, lines)
+}
+
+// CompareSpectra compares a reading against a reference spectrum on their
+// common grid and returns the top bins ranked by absolute difference
+func CompareSpectra(reference, reading Spectrum, top int) SpectrumComparison {
+	reference, reading = CommonGrid(reference, reading)
+
+	lines := len(reading.Amplitudes)
+	result := SpectrumComparison{
+		BinWidth:   reading.BinWidth,
+		Lines:      lines,
+		Difference: make([]float64, lines),
+		Ratio:      make([]*float64, lines),
+	}
+
+	changes := make([]BinChange, lines)
+	for k := 0; k < lines; k++ {
+		ref, cur := reference.Amplitudes[k], reading.Amplitudes[k]
+		result.Difference[k] = cur - ref
+		if ref != 0 {
+			ratio := cur / ref
+			result.Ratio[k] = &ratio
+		}
+		changes[k] = BinChange{
+			Bin:        k,
+			Frequency:  float64(k) * reading.BinWidth,
+			Reference:  ref,
+			Reading:    cur,
+			Difference: result.Difference[k],
+			Ratio:      result.Ratio[k],
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return math.Abs(changes[i].Difference) > math.Abs(changes[j].Difference)
+	})
+	if top > len(changes) {
+		top = len(changes)
+	}
+	result.TopChanges = changes[:top]
+	return result
+}
@@ -0,0 +1,69 @@
+package analysis
+
+import (
+	"math"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+)
+
+// BearingFrequencies are the defect frequencies of a bearing in Hz
+type BearingFrequencies struct {
+	BPFO float64 `json:"bpfo"` // Ball pass frequency, outer race
+	BPFI float64 `json:"bpfi"` // Ball pass frequency, inner race
+	BSF  float64 `json:"bsf"`  // Ball spin frequency
+	FTF  float64 `json:"ftf"`  // Fundamental train (cage) frequency
+}
+
+// DefectFrequencies computes bearing defect frequencies for a shaft speed in Hz
+func DefectFrequencies(bearing models.BearingGeometry, shaftHz float64) BearingFrequencies {
+	ratio := bearing.BallDiameter / bearing.PitchDiameter * math.Cos(bearing.ContactAngle*math.Pi/180)
+	n := float64(bearing.Balls)
+
+	return BearingFrequencies{
+		BPFO: n / 2 * shaftHz * (1 - ratio),
+		BPFI: n / 2 * shaftHz * (1 + ratio),
+		BSF:  bearing.PitchDiameter / (2 * bearing.BallDiameter) * shaftHz * (1 - ratio*ratio),
+		FTF:  shaftHz / 2 * (1 - ratio),
+	}
+}
+
+// AmplitudeAt returns the largest amplitude within tolerance bins of frequency f
+func AmplitudeAt(amplitudes []float64, binWidth, f float64, tolerance int) float64 {
+	if binWidth <= 0 || f < 0 {
+		return 0
+	}
+
+	center := int(math.Round(f / binWidth))
+	var peak float64
+	for k := center - tolerance; k <= center+tolerance; k++ {
+		if k >= 0 && k < len(amplitudes) {
+			peak = math.Max(peak, amplitudes[k])
+		}
+	}
+	return peak
+}
+
+// BandRMS returns the overall RMS of the bins in [low, high) Hz. Peak
+// amplitudes contribute A²/2 each; RMS amplitudes contribute A².
+func BandRMS(amplitudes []float64, binWidth, low, high float64, scaling string) float64 {
+	if binWidth <= 0 {
+		return 0
+	}
+
+	var sum float64
+	for k, a := range amplitudes {
+		f := float64(k) * binWidth
+		if f < low || f >= high {
+			continue
+		}
+		switch scaling {
+		case models.ScalingRMS:
+			sum += a * a
+		case models.ScalingPeakToPeak:
+			sum += a * a / 8
+		default:
+			sum += a * a / 2
+		}
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// FFT computes the discrete Fourier transform of x. Power-of-two lengths use
+// an iterative radix-2 transform; other lengths use Bluestein's algorithm,
+// which is also O(n log n).
+func FFT(x []complex128) []complex128 {
+	n := len(x)
+	if n == 0 {
+		return nil
+	}
+	if n&(n-1) != 0 {
+		return bluestein(x)
+	}
+
+	out := make([]complex128, n)
+	shift := 64 - bits.TrailingZeros(uint(n))
+	for i := range x {
+		out[bits.Reverse64(uint64(i))>>shift] = x[i]
+	}
+	if n == 1 {
+		return out
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even := out[start+k]
+				odd := w * out[start+k+size/2]
+				out[start+k] = even + odd
+				out[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+
+	return out
+}
+
+// bluestein computes the DFT of any length as a convolution with a chirp,
+// carried out with radix-2 transforms of at least 2n-1 points
+func bluestein(x []complex128) []complex128 {
+	n := len(x)
+	m := 1 << bits.Len(uint(2*n-2))
+
+	// chirp[k] = exp(-iπk²/n); k² is reduced mod 2n to keep the angle exact
+	chirp := make([]complex128, n)
+	for k := range chirp {
+		angle := math.Pi * float64(k*k%(2*n)) / float64(n)
+		chirp[k] = cmplx.Rect(1, -angle)
+	}
+
+	a := make([]complex128, m)
+	b := make([]complex128, m)
+	for k := 0; k < n; k++ {
+		a[k] = x[k] * chirp[k]
+		b[k] = cmplx.Conj(chirp[k])
+		if k > 0 {
+			b[m-k] = b[k]
+		}
+	}
+
+	A, B := FFT(a), FFT(b)
+	for i := range A {
+		A[i] = cmplx.Conj(A[i] * B[i])
+	}
+	conv := FFT(A)
+
+	out := make([]complex128, n)
+	for k := range out {
+		out[k] = cmplx.Conj(conv[k]) / complex(float64(m), 0) * chirp[k]
+	}
+	return out
+}
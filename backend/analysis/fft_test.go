@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+)
+
+// naiveDFT is the O(n²) definition the transforms are checked against
+func naiveDFT(x []complex128) []complex128 {
+	n := len(x)
+	out := make([]complex128, n)
+	for k := range out {
+		for t, v := range x {
+			out[k] += v * cmplx.Rect(1, -2*math.Pi*float64(k*t%n)/float64(n))
+		}
+	}
+	return out
+}
+
+func TestFFTMatchesDFT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 3, 5, 7, 8, 12, 64, 100, 127, 1000, 1024} {
+		x := make([]complex128, n)
+		for i := range x {
+			x[i] = complex(rng.Float64()*2-1, rng.Float64()*2-1)
+		}
+
+		got, want := FFT(x), naiveDFT(x)
+		if len(got) != n {
+			t.Fatalf("n=%d: got %d bins", n, len(got))
+		}
+		for k := range want {
+			if cmplx.Abs(got[k]-want[k]) > 1e-9*float64(n) {
+				t.Errorf("n=%d: bin %d = %v, want %v", n, k, got[k], want[k])
+				break
+			}
+		}
+	}
+}
+
+func TestFFTEmpty(t *testing.T) {
+	if got := FFT(nil); got != nil {
+		t.Errorf("FFT(nil) = %v, want nil", got)
+	}
+}
+
+func TestFFTSinusoid(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		bin  int
+	}{
+		{"power of two", 64, 5},
+		{"power of two, high bin", 1024, 300},
+		{"odd length", 99, 7},
+		{"even length, not a power of two", 1000, 123},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// cos puts n/2 into the bin and its mirror and nothing elsewhere
+			x := make([]complex128, tt.n)
+			for i := range x {
+				x[i] = complex(math.Cos(2*math.Pi*float64(tt.bin*i)/float64(tt.n)), 0)
+			}
+
+			X := FFT(x)
+			for k, v := range X {
+				want := 0.0
+				if k == tt.bin || k == tt.n-tt.bin {
+					want = float64(tt.n) / 2
+				}
+				if math.Abs(cmplx.Abs(v)-want) > 1e-9*float64(tt.n) {
+					t.Errorf("|X[%d]| = %v, want %v", k, cmplx.Abs(v), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package analysis
+
+import (
+	"errors"
+	"math"
+)
+
+// Regression models for trend forecasting
+const (
+	ModelLinear      = "linear"
+	ModelExponential = "exponential"
+)
+
+// z value for a two-sided 95% confidence interval
+const confidenceZ = 1.96
+
+// TrendFit is a least squares fit of y = Intercept + Slope*x, in log space for
+// the exponential model. The line passes through (MeanX, MeanY).
+type TrendFit struct {
+	Model     string  `json:"model"`
+	Slope     float64 `json:"slope"`
+	Intercept float64 `json:"intercept"`
+	SlopeErr  float64 `json:"slope_std_err"`
+	R2        float64 `json:"r2"`
+	N         int     `json:"n"`
+	MeanX     float64 `json:"-"`
+	MeanY     float64 `json:"-"`
+}
+
+// FitTrend fits a linear or exponential degradation model to a series.
+// The exponential model fits ln(y) and ignores non-positive values.
+func FitTrend(x, y []float64, model string) (TrendFit, error) {
+	fit := TrendFit{Model: model}
+	if model == ModelExponential {
+		var lx, ly []float64
+		for i := range y {
+			if y[i] > 0 {
+				lx = append(lx, x[i])
+				ly = append(ly, math.Log(y[i]))
+			}
+		}
+		x, y = lx, ly
+	} else if model != ModelLinear {
+		return fit, errors.New("model must be linear or exponential")
+	}
+
+	n := len(x)
+	if n < 3 {
+		return fit, errors.New("at least three points are needed for a trend")
+	}
+	fit.N = n
+	fit.MeanX, fit.MeanY = Mean(x), Mean(y)
+
+	var sxx, sxy, syy float64
+	for i := range x {
+		dx, dy := x[i]-fit.MeanX, y[i]-fit.MeanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return fit, errors.New("points must span more than one time")
+	}
+
+	fit.Slope = sxy / sxx
+	fit.Intercept = fit.MeanY - fit.Slope*fit.MeanX
+
+	var sse float64
+	for i := range x {
+		r := y[i] - (fit.Intercept + fit.Slope*x[i])
+		sse += r * r
+	}
+	if syy > 0 {
+		fit.R2 = 1 - sse/syy
+	}
+	fit.SlopeErr = math.Sqrt(sse/float64(n-2)) / math.Sqrt(sxx)
+	return fit, nil
+}
+
+// Predict returns the fitted value at x in the original units
+func (f TrendFit) Predict(x float64) float64 {
+	y := f.Intercept + f.Slope*x
+	if f.Model == ModelExponential {
+		return math.Exp(y)
+	}
+	return y
+}
+
+// Crossing returns the x at which the fit reaches threshold, with the 95%
+// confidence bounds from the slope's standard error. ok is false when the fit
+// does not rise towards the threshold; a bound is +Inf when the slope at that
+// end of the interval does not rise.
+func (f TrendFit) Crossing(threshold float64) (at, earliest, latest float64, ok bool) {
+	target := threshold
+	if f.Model == ModelExponential {
+		if threshold <= 0 {
+			return 0, 0, 0, false
+		}
+		target = math.Log(threshold)
+	}
+
+	cross := func(slope float64) float64 {
+		if slope <= 0 {
+			return math.Inf(1)
+		}
+		return f.MeanX + (target-f.MeanY)/slope
+	}
+
+	if f.Slope <= 0 {
+		return 0, 0, 0, false
+	}
+	return cross(f.Slope), cross(f.Slope + confidenceZ*f.SlopeErr), cross(f.Slope - confidenceZ*f.SlopeErr), true
+}
@@ -0,0 +1,65 @@
+package analysis
+
+import (
+	"math"
+	"time"
+)
+
+// Point is one sample of a time series
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// LTTB downsamples a time-ordered series to threshold points with the
+// Largest-Triangle-Three-Buckets algorithm, which keeps the visual shape
+// of the series for charting. Series at or below the threshold are returned as is.
+func LTTB(points []Point, threshold int) []Point {
+	if threshold >= len(points) || threshold < 3 {
+		return points
+	}
+
+	sampled := make([]Point, 0, threshold)
+	sampled = append(sampled, points[0])
+
+	// Buckets exclude the first and last points, which are always kept
+	every := float64(len(points)-2) / float64(threshold-2)
+	a := 0
+
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket is the third triangle vertex
+		nextStart := int(math.Floor(float64(i+1)*every)) + 1
+		nextEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if nextEnd > len(points) {
+			nextEnd = len(points)
+		}
+		var avgX, avgY float64
+		for _, p := range points[nextStart:nextEnd] {
+			avgX += float64(p.Time.UnixNano())
+			avgY += p.Value
+		}
+		count := float64(nextEnd - nextStart)
+		avgX /= count
+		avgY /= count
+
+		start := int(math.Floor(float64(i)*every)) + 1
+		end := int(math.Floor(float64(i+1)*every)) + 1
+
+		ax := float64(points[a].Time.UnixNano())
+		ay := points[a].Value
+		maxArea := -1.0
+		next := start
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(points[j].Value-ay) - (ax-float64(points[j].Time.UnixNano()))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+
+		sampled = append(sampled, points[next])
+		a = next
+	}
+
+	return append(sampled, points[len(points)-1])
+}
@@ -0,0 +1,80 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func series(values ...float64) []Point {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := make([]Point, len(values))
+	for i, v := range values {
+		points[i] = Point{Time: start.Add(time.Duration(i) * time.Minute), Value: v}
+	}
+	return points
+}
+
+func TestLTTBUnchanged(t *testing.T) {
+	points := series(1, 5, 2, 8, 3)
+
+	tests := []struct {
+		name      string
+		points    []Point
+		threshold int
+	}{
+		{"empty", nil, 10},
+		{"threshold above length", points, 10},
+		{"threshold equal to length", points, len(points)},
+		{"threshold of two", points, 2},
+		{"threshold of zero", points, 0},
+		{"negative threshold", points, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LTTB(tt.points, tt.threshold)
+			if len(got) != len(tt.points) {
+				t.Fatalf("got %d points, want the %d given", len(got), len(tt.points))
+			}
+			for i := range got {
+				if got[i] != tt.points[i] {
+					t.Errorf("point %d = %v, want %v", i, got[i], tt.points[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLTTBDownsamples(t *testing.T) {
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = math.Sin(float64(i) / 50)
+	}
+	values[437] = 25 // A spike the chart must show
+	points := series(values...)
+
+	for _, threshold := range []int{3, 10, 100, len(points) - 1} {
+		got := LTTB(points, threshold)
+		if len(got) != threshold {
+			t.Fatalf("threshold %d: got %d points", threshold, len(got))
+		}
+		if got[0] != points[0] || got[len(got)-1] != points[len(points)-1] {
+			t.Errorf("threshold %d: first and last points not kept", threshold)
+		}
+		for i := 1; i < len(got); i++ {
+			if !got[i].Time.After(got[i-1].Time) {
+				t.Errorf("threshold %d: point %d is not after the one before it", threshold, i)
+				break
+			}
+		}
+
+		spike := false
+		for _, p := range got {
+			spike = spike || p.Value == 25
+		}
+		if !spike && threshold > 3 {
+			t.Errorf("threshold %d: spike dropped", threshold)
+		}
+	}
+}
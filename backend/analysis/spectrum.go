@@ -0,0 +1,126 @@
+package analysis
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+)
+
+// Standard gravity, for converting acceleration in g to m/s²
+const StandardGravity = 9.80665
+
+// Spectrum is a single-sided amplitude spectrum
+type Spectrum struct {
+	BinWidth   float64   // Hz between bins; bin k is at k*BinWidth
+	Amplitudes []float64 // Peak amplitude per bin
+}
+
+// AmplitudeSpectrum windows a time waveform and returns its single-sided
+// peak amplitude spectrum, corrected for the window's coherent gain
+func AmplitudeSpectrum(samples []float64, sampleRate float64, window string) (Spectrum, error) {
+	n := len(samples)
+	if n < 2 {
+		return Spectrum{}, errors.New("waveform needs at least two samples")
+	}
+	if sampleRate <= 0 {
+		return Spectrum{}, errors.New("sample rate must be positive")
+	}
+
+	w, err := Window(window, n)
+	if err != nil {
+		return Spectrum{}, err
+	}
+
+	// Remove the DC offset so it does not leak into low bins
+	mean := Mean(samples)
+	x := make([]complex128, n)
+	for i, v := range samples {
+		x[i] = complex((v-mean)*w[i], 0)
+	}
+
+	X := FFT(x)
+	scale := 2 / (float64(n) * coherentGain(w))
+
+	amplitudes := make([]float64, n/2+1)
+	for k := range amplitudes {
+		amplitudes[k] = cmplx.Abs(X[k]) * scale
+	}
+	amplitudes[0] /= 2
+	if n%2 == 0 {
+		amplitudes[n/2] /= 2
+	}
+
+	return Spectrum{BinWidth: sampleRate / float64(n), Amplitudes: amplitudes}, nil
+}
+
+// Resample linearly interpolates the spectrum onto lines bins of width binWidth
+func (s Spectrum) Resample(binWidth float64, lines int) Spectrum {
+	out := make([]float64, lines)
+	last := len(s.Amplitudes) - 1
+	for k := range out {
+		pos := float64(k) * binWidth / s.BinWidth
+		i := int(pos)
+		if i >= last {
+			out[k] = s.Amplitudes[last]
+			continue
+		}
+		frac := pos - float64(i)
+		out[k] = s.Amplitudes[i]*(1-frac) + s.Amplitudes[i+1]*frac
+	}
+	return Spectrum{BinWidth: binWidth, Amplitudes: out}
+}
+
+// VelocitySpectrum integrates an acceleration spectrum in g to velocity in
+// mm/s by dividing each bin by 2πf. The DC bin is set to zero.
+func VelocitySpectrum(acceleration Spectrum) Spectrum {
+	out := make([]float64, len(acceleration.Amplitudes))
+	for k := 1; k < len(out); k++ {
+		f := float64(k) * acceleration.BinWidth
+		out[k] = acceleration.Amplitudes[k] * StandardGravity / (2 * math.Pi * f) * 1000
+	}
+	return Spectrum{BinWidth: acceleration.BinWidth, Amplitudes: out}
+}
+
+// Mean returns the arithmetic mean of values
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// RMS returns the root mean square of a waveform after removing its mean
+func RMS(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	mean := Mean(samples)
+	var sum float64
+	for _, v := range samples {
+		sum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// Peak returns the largest absolute deviation from the mean
+func Peak(samples []float64) float64 {
+	mean := Mean(samples)
+	var peak float64
+	for _, v := range samples {
+		peak = math.Max(peak, math.Abs(v-mean))
+	}
+	return peak
+}
+
+// CrestFactor returns peak divided by RMS
+func CrestFactor(samples []float64) float64 {
+	rms := RMS(samples)
+	if rms == 0 {
+		return 0
+	}
+	return Peak(samples) / rms
+}
@@ -0,0 +1,187 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+func sineWave(n int, sampleRate, frequency, amplitude, offset float64) []float64 {
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = offset + amplitude*math.Sin(2*math.Pi*frequency*float64(i)/sampleRate)
+	}
+	return samples
+}
+
+// peakAmplitude returns the largest amplitude within two bins of f
+func peakAmplitude(s Spectrum, f float64) float64 {
+	center := int(math.Round(f / s.BinWidth))
+	var peak float64
+	for k := center - 2; k <= center+2; k++ {
+		if k >= 0 && k < len(s.Amplitudes) {
+			peak = math.Max(peak, s.Amplitudes[k])
+		}
+	}
+	return peak
+}
+
+func TestAmplitudeSpectrum(t *testing.T) {
+	const (
+		n          = 4096
+		sampleRate = 4096.0
+		amplitude  = 2.0
+	)
+
+	tests := []struct {
+		name      string
+		window    string
+		frequency float64
+		tolerance float64 // Relative amplitude error
+	}{
+		// On a bin, every window's coherent gain correction is exact
+		{"rectangular on a bin", WindowRectangular, 100, 1e-6},
+		{"hann on a bin", WindowHann, 100, 1e-3},
+		{"default window is hann", "", 100, 1e-3},
+		{"flat-top on a bin", WindowFlatTop, 100, 1e-3},
+		// Halfway between bins, Hann loses up to 15% and flat-top stays flat
+		{"hann between bins", WindowHann, 100.5, 0.16},
+		{"flat-top between bins", WindowFlatTop, 100.5, 0.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The offset checks that DC is removed before windowing
+			samples := sineWave(n, sampleRate, tt.frequency, amplitude, 3)
+			spectrum, err := AmplitudeSpectrum(samples, sampleRate, tt.window)
+			if err != nil {
+				t.Fatalf("AmplitudeSpectrum: %v", err)
+			}
+
+			if spectrum.BinWidth != sampleRate/n {
+				t.Errorf("BinWidth = %v, want %v", spectrum.BinWidth, sampleRate/n)
+			}
+			if len(spectrum.Amplitudes) != n/2+1 {
+				t.Errorf("got %d bins, want %d", len(spectrum.Amplitudes), n/2+1)
+			}
+			if got := peakAmplitude(spectrum, tt.frequency); math.Abs(got-amplitude)/amplitude > tt.tolerance {
+				t.Errorf("amplitude at %v Hz = %v, want %v within %v", tt.frequency, got, amplitude, tt.tolerance)
+			}
+			// Off-bin sines do not average to zero over the waveform, so a
+			// little DC is left, far below the offset
+			if spectrum.Amplitudes[0] > 0.01 {
+				t.Errorf("DC amplitude = %v, want the offset of 3 removed", spectrum.Amplitudes[0])
+			}
+		})
+	}
+}
+
+func TestAmplitudeSpectrumNonPowerOfTwo(t *testing.T) {
+	const sampleRate = 1000.0
+	samples := sineWave(1000, sampleRate, 50, 1, 0)
+
+	spectrum, err := AmplitudeSpectrum(samples, sampleRate, WindowFlatTop)
+	if err != nil {
+		t.Fatalf("AmplitudeSpectrum: %v", err)
+	}
+	if got := peakAmplitude(spectrum, 50); math.Abs(got-1) > 1e-3 {
+		t.Errorf("amplitude at 50 Hz = %v, want 1", got)
+	}
+}
+
+func TestAmplitudeSpectrumInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		samples    []float64
+		sampleRate float64
+		window     string
+	}{
+		{"one sample", []float64{1}, 100, WindowHann},
+		{"zero sample rate", []float64{1, 2, 3}, 0, WindowHann},
+		{"unknown window", []float64{1, 2, 3}, 100, "blackman"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := AmplitudeSpectrum(tt.samples, tt.sampleRate, tt.window); err == nil {
+				t.Error("AmplitudeSpectrum succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window string
+		gain   float64 // Coherent gain for a long window
+	}{
+		{"rectangular", WindowRectangular, 1},
+		{"hann", WindowHann, 0.5},
+		{"flat-top", WindowFlatTop, 0.21557895},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := Window(tt.window, 10001)
+			if err != nil {
+				t.Fatalf("Window: %v", err)
+			}
+			if got := coherentGain(w); math.Abs(got-tt.gain) > 1e-3 {
+				t.Errorf("coherent gain = %v, want %v", got, tt.gain)
+			}
+			// Symmetric windows peak at 1 in the middle
+			if got := w[len(w)/2]; math.Abs(got-1) > 1e-6 {
+				t.Errorf("center coefficient = %v, want 1", got)
+			}
+		})
+	}
+
+	if w, err := Window(WindowHann, 1); err != nil || len(w) != 1 || w[0] != 1 {
+		t.Errorf("Window(hann, 1) = %v, %v, want [1]", w, err)
+	}
+	if _, err := Window("blackman", 8); err == nil {
+		t.Error("Window(blackman) succeeded, want an error")
+	}
+}
+
+func TestVelocitySpectrum(t *testing.T) {
+	acceleration := Spectrum{BinWidth: 10, Amplitudes: []float64{5, 1, 1, 0.5}}
+	velocity := VelocitySpectrum(acceleration)
+
+	// v = a·g / (2πf), in mm/s
+	want := []float64{
+		0,
+		1 * StandardGravity / (2 * math.Pi * 10) * 1000,
+		1 * StandardGravity / (2 * math.Pi * 20) * 1000,
+		0.5 * StandardGravity / (2 * math.Pi * 30) * 1000,
+	}
+	if velocity.BinWidth != acceleration.BinWidth {
+		t.Errorf("BinWidth = %v, want %v", velocity.BinWidth, acceleration.BinWidth)
+	}
+	for k := range want {
+		if math.Abs(velocity.Amplitudes[k]-want[k]) > 1e-9 {
+			t.Errorf("bin %d = %v, want %v", k, velocity.Amplitudes[k], want[k])
+		}
+	}
+}
+
+func TestWaveformStatistics(t *testing.T) {
+	// A sine of amplitude 2 around an offset of 5
+	samples := sineWave(1000, 1000, 10, 2, 5)
+
+	if got := Mean(samples); math.Abs(got-5) > 1e-9 {
+		t.Errorf("Mean = %v, want 5", got)
+	}
+	if got := RMS(samples); math.Abs(got-2/math.Sqrt2) > 1e-9 {
+		t.Errorf("RMS = %v, want %v", got, 2/math.Sqrt2)
+	}
+	if got := Peak(samples); math.Abs(got-2) > 1e-9 {
+		t.Errorf("Peak = %v, want 2", got)
+	}
+	if got := CrestFactor(samples); math.Abs(got-math.Sqrt2) > 1e-9 {
+		t.Errorf("CrestFactor = %v, want %v", got, math.Sqrt2)
+	}
+	if got := CrestFactor([]float64{3, 3, 3}); got != 0 {
+		t.Errorf("CrestFactor of a constant = %v, want 0", got)
+	}
+}
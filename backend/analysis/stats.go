@@ -0,0 +1,64 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+)
+
+// RunningStats accumulates mean and variance in one pass (Welford's method)
+type RunningStats struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+// Add includes a value in the statistics
+func (s *RunningStats) Add(value float64) {
+	s.n++
+	delta := value - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (value - s.mean)
+}
+
+// Count returns the number of values added
+func (s *RunningStats) Count() int {
+	return s.n
+}
+
+// Mean returns the mean of the values added
+func (s *RunningStats) Mean() float64 {
+	return s.mean
+}
+
+// StdDev returns the sample standard deviation, or 0 for fewer than two values
+func (s *RunningStats) StdDev() float64 {
+	if s.n < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.n-1))
+}
+
+// Percentile returns the p quantile (0 to 1) of values, interpolating
+// linearly between the closest ranks, or 0 for no values
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// ZScore returns how many standard deviations value lies from mean. A zero
+// spread is floored to a small fraction of the mean so that a perfectly steady
+// baseline still yields a finite score.
+func ZScore(value, mean, stdDev float64) float64 {
+	floor := math.Max(1e-3*math.Abs(mean), 1e-9)
+	return (value - mean) / math.Max(stdDev, floor)
+}
@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+)
+
+// Window functions for spectral analysis
+const (
+	WindowRectangular = "rectangular"
+	WindowHann        = "hann"
+	WindowFlatTop     = "flattop"
+)
+
+// Five-term flat-top coefficients, as used by MATLAB flattopwin
+var flatTopCoefficients = []float64{0.21557895, 0.41663158, 0.277263158, 0.083578947, 0.006947368}
+
+// Window returns the coefficients of the named window for n samples
+func Window(name string, n int) ([]float64, error) {
+	w := make([]float64, n)
+	if n == 1 {
+		w[0] = 1
+		return w, nil
+	}
+
+	switch name {
+	case WindowRectangular:
+		for i := range w {
+			w[i] = 1
+		}
+	case WindowHann, "":
+		for i := range w {
+			w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+		}
+	case WindowFlatTop:
+		for i := range w {
+			x := 2 * math.Pi * float64(i) / float64(n-1)
+			for k, a := range flatTopCoefficients {
+				sign := 1.0
+				if k%2 == 1 {
+					sign = -1
+				}
+				w[i] += sign * a * math.Cos(float64(k)*x)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown window %q", name)
+	}
+
+	return w, nil
+}
+
+// coherentGain is the mean of the window, used to correct amplitude scaling
+func coherentGain(w []float64) float64 {
+	var sum float64
+	for _, v := range w {
+		sum += v
+	}
+	return sum / float64(len(w))
+}
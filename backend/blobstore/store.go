@@ -0,0 +1,46 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+)
+
+// ErrNotFound is returned by Get for a missing key
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps binary objects under slash-separated keys
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var defaultStore Store
+
+// Setup configures the default store from the application config. Without a
+// configured backend Default returns nil and spectra stay inline.
+func Setup(cfg *config.Config) error {
+	switch cfg.BlobStore {
+	case "":
+		defaultStore = nil
+	case "file":
+		defaultStore = &FileStore{Root: cfg.BlobDir}
+	case "s3":
+		store, err := NewS3Store(cfg.S3Endpoint, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3Bucket, cfg.S3Region, cfg.S3UseSSL)
+		if err != nil {
+			return err
+		}
+		defaultStore = store
+	default:
+		return fmt.Errorf("unknown blob store %q, expected file or s3", cfg.BlobStore)
+	}
+	return nil
+}
+
+// Default returns the configured store, or nil when none is configured
+func Default() Store {
+	return defaultStore
+}
@@ -0,0 +1,188 @@
+// Command migrate-timeseries converts the regular vibrations collection into a
+// time-series collection, copying documents in batches and reporting progress.
+//
+// MongoDB cannot rename time-series collections, so the regular collection is
+// renamed to -legacy first, a time-series collection is created under the
+// original name, and documents are copied back into it. The API keeps using
+// "vibrations" unchanged. Stop ingestion while the tool runs: it resumes after
+// the highest _id already copied, which new readings would move forward.
+//
+// With -backfill-timestamps it instead fills in measured_at, received_at and
+// created_at on readings stored before device timestamps were kept, without
+// converting the collection. The server also does this in the background
+// when it starts.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	collection := flag.String("collection", "vibrations", "collection to convert")
+	legacy := flag.String("legacy", "", "name for the original collection (default <collection>_legacy)")
+	granularity := flag.String("granularity", config.GetConfig().TimeSeriesGranularity, "time-series granularity: seconds, minutes or hours")
+	batchSize := flag.Int("batch", 1000, "documents per insert")
+	backfill := flag.Bool("backfill-timestamps", false, "fill in missing reading timestamps and exit")
+	flag.Parse()
+
+	if *legacy == "" {
+		*legacy = *collection + "_legacy"
+	}
+
+	if err := config.ConnectDB(); err != nil {
+		log.Fatal("Failed to connect to MongoDB:", err)
+	}
+	ctx := context.Background()
+
+	if *backfill {
+		updated, err := config.BackfillTimestamps(ctx, *collection, *batchSize)
+		if err != nil {
+			log.Fatal("Failed to backfill timestamps:", err)
+		}
+		log.Printf("Backfilled timestamps on %d readings in %s", updated, *collection)
+		return
+	}
+
+	kind, err := config.CollectionType(ctx, *collection)
+	if err != nil {
+		log.Fatal("Failed to inspect collection:", err)
+	}
+	legacyKind, err := config.CollectionType(ctx, *legacy)
+	if err != nil {
+		log.Fatal("Failed to inspect legacy collection:", err)
+	}
+
+	switch {
+	case kind == "collection" && legacyKind == "":
+		if err := renameCollection(ctx, *collection, *legacy); err != nil {
+			log.Fatal("Failed to rename collection:", err)
+		}
+		log.Printf("Renamed %s to %s", *collection, *legacy)
+		kind = ""
+	case kind == "collection":
+		log.Fatalf("%s and %s both exist as regular collections; nothing to resume", *collection, *legacy)
+	case legacyKind == "":
+		log.Fatalf("%s not found; nothing to migrate", *legacy)
+	}
+
+	switch kind {
+	case "":
+		if err := config.CreateVibrationsTimeSeries(ctx, *collection, *granularity); err != nil {
+			log.Fatal("Failed to create time-series collection:", err)
+		}
+		log.Printf("Created time-series collection %s (granularity %s)", *collection, *granularity)
+	case "timeseries":
+		log.Printf("Resuming migration into %s", *collection)
+	default:
+		log.Fatalf("%s is a %s, not a time-series collection", *collection, kind)
+	}
+
+	if err := copyCollection(ctx, config.GetCollection(*legacy), config.GetCollection(*collection), *batchSize); err != nil {
+		log.Fatal("Migration failed:", err)
+	}
+
+	if err := config.EnsureIndexes(); err != nil {
+		log.Fatal("Failed to create indexes:", err)
+	}
+	log.Printf("Migration complete; drop %s once the new collection is verified", *legacy)
+}
+
+// copyCollection copies documents in _id order, starting after the highest
+// _id already present in the target so that an interrupted run can resume.
+// Time-series collections do not enforce unique _id, so copies must not overlap.
+// Readings from before device timestamps get measured_at from created_at,
+// and readings stored without created_at get it from measured_at, since the
+// time field is required.
+func copyCollection(ctx context.Context, source, target *mongo.Collection, batchSize int) error {
+	filter := bson.M{}
+
+	var last bson.M
+	err := target.FindOne(ctx, bson.M{}, options.FindOne().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetProjection(bson.M{"_id": 1})).Decode(&last)
+	if err == nil {
+		filter["_id"] = bson.M{"$gt": last["_id"]}
+		log.Printf("Resuming after _id %v", last["_id"])
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	total, err := source.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	log.Printf("Copying %d documents from %s to %s", total, source.Name(), target.Name())
+
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$set": bson.M{
+			"measured_at": bson.M{"$ifNull": bson.A{"$measured_at", "$created_at"}},
+			"received_at": bson.M{"$ifNull": bson.A{"$received_at", "$created_at"}},
+			"created_at":  bson.M{"$ifNull": bson.A{"$created_at", "$measured_at"}},
+		}},
+	}
+	cursor, err := source.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(int32(batchSize)))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	start := time.Now()
+	var copied int64
+	batch := make([]interface{}, 0, batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := target.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+		copied += int64(len(batch))
+		batch = batch[:0]
+
+		percent := 100.0
+		if total > 0 {
+			percent = float64(copied) / float64(total) * 100
+		}
+		rate := float64(copied) / time.Since(start).Seconds()
+		log.Printf("Copied %d/%d (%.1f%%, %.0f docs/s)", copied, total, percent, rate)
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		// Current is reused by the cursor, so each document is copied
+		batch = append(batch, append(bson.Raw(nil), cursor.Current...))
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Copied %d documents in %s", copied, time.Since(start).Round(time.Second))
+	return nil
+}
+
+func renameCollection(ctx context.Context, from, to string) error {
+	db := config.GetCollection(from).Database()
+	return db.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + from},
+		{Key: "to", Value: db.Name() + "." + to},
+	}).Err()
+}
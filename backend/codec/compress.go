@@ -0,0 +1,64 @@
+package codec
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrBodyTooLarge is returned when a decompressed body exceeds its limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// Decompress wraps body according to a Content-Encoding header value.
+// The returned reader fails with ErrBodyTooLarge after maxBytes of output,
+// which protects against compression bombs.
+func Decompress(body io.Reader, contentEncoding string, maxBytes int64) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		reader = io.NopCloser(body)
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %v", err)
+		}
+		reader = gz
+	case "zstd":
+		zr, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %v", err)
+		}
+		reader = zr.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", contentEncoding)
+	}
+
+	return &limitedReader{ReadCloser: reader, remaining: maxBytes}, nil
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Allow a clean EOF exactly at the limit
+		var probe [1]byte
+		if n, _ := l.ReadCloser.Read(probe[:]); n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
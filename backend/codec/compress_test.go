@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstded(t *testing.T, data []byte) []byte {
+	t.Helper()
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	return w.EncodeAll(data, nil)
+}
+
+func TestDecompress(t *testing.T) {
+	payload := []byte(strings.Repeat(`{"rms_x":0.5}`, 100))
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		maxBytes int64
+		err      error
+	}{
+		{"identity", "", payload, 1 << 20, nil},
+		{"explicit identity", "identity", payload, 1 << 20, nil},
+		{"gzip", "gzip", gzipped(t, payload), 1 << 20, nil},
+		{"x-gzip, mixed case", " X-GZIP ", gzipped(t, payload), 1 << 20, nil},
+		{"zstd", "zstd", zstded(t, payload), 1 << 20, nil},
+		{"exactly at the limit", "gzip", gzipped(t, payload), int64(len(payload)), nil},
+		{"identity over the limit", "", payload, int64(len(payload)) - 1, ErrBodyTooLarge},
+		{"gzip over the limit", "gzip", gzipped(t, payload), 64, ErrBodyTooLarge},
+		{"zstd over the limit", "zstd", zstded(t, payload), 64, ErrBodyTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := Decompress(bytes.NewReader(tt.body), tt.encoding, tt.maxBytes)
+			if err != nil {
+				t.Fatalf("Decompress: %v", err)
+			}
+			defer reader.Close()
+
+			got, err := io.ReadAll(reader)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("read error = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && !bytes.Equal(got, payload) {
+				t.Errorf("decoded %d bytes, want the %d byte payload", len(got), len(payload))
+			}
+		})
+	}
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		err      string
+	}{
+		{"unsupported encoding", "br", []byte("x"), "unsupported Content-Encoding"},
+		{"not gzip", "gzip", []byte("plain text"), "invalid gzip body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decompress(bytes.NewReader(tt.body), tt.encoding, 1<<20)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Decompress error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
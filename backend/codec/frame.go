@@ -0,0 +1,277 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+)
+
+// FrameContentType is the Content-Type for binary vibration frames
+const FrameContentType = "application/vnd.vibration.frame"
+
+// Sample formats for spectrum values
+const (
+	FormatFloat32 uint8 = 1
+	FormatFloat16 uint8 = 2
+)
+
+// Largest accepted spectrum length per axis
+const maxFrameLines = 1 << 16
+
+// Frame versions. Version 2 adds the device timestamp and message ID.
+const (
+	frameVersion1 uint8 = 1
+	frameVersion2 uint8 = 2
+)
+
+var frameMagic = [4]byte{'V', 'I', 'B', 'F'}
+
+// frameHeader is the fixed part of a frame. All values are little-endian.
+//
+//	offset  size  field
+//	0       4     magic "VIBF"
+//	4       1     version, 1 or 2
+//	5       1     sample format: 1 = float32, 2 = float16
+//	6       2     serial number length in bytes (S), 0 when the URL identifies the sensor
+//	8       4     spectrum lines per axis (N)
+//	12      24    rms_x, rms_y, rms_z, peak_x, peak_y, peak_z as float32
+//
+// Version 1 continues with:
+//
+//	36      S     serial number, UTF-8
+//	36+S    3*N   fft_x, fft_y, fft_z samples in the sample format
+//
+// Version 2 continues with frameHeaderV2:
+//
+//	36      8     measured_at in Unix milliseconds as int64, 0 when the device has no clock
+//	44      2     message ID length in bytes (M), 0 when the device sends none
+//	46      S     serial number, UTF-8
+//	46+S    M     message ID, UTF-8
+//	46+S+M  3*N   fft_x, fft_y, fft_z samples in the sample format
+type frameHeader struct {
+	Magic     [4]byte
+	Version   uint8
+	Format    uint8
+	SerialLen uint16
+	Lines     uint32
+	RMSX      float32
+	RMSY      float32
+	RMSZ      float32
+	PeakX     float32
+	PeakY     float32
+	PeakZ     float32
+}
+
+// frameHeaderV2 follows frameHeader in version 2 frames
+type frameHeaderV2 struct {
+	MeasuredAt   int64
+	MessageIDLen uint16
+}
+
+// DecodeFrame reads one binary frame into a VibrationData
+func DecodeFrame(r io.Reader) (models.VibrationData, error) {
+	var vibration models.VibrationData
+
+	var header frameHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return vibration, fmt.Errorf("invalid frame header: %v", err)
+	}
+	if header.Magic != frameMagic {
+		return vibration, errors.New("invalid frame magic")
+	}
+	if header.Version != frameVersion1 && header.Version != frameVersion2 {
+		return vibration, fmt.Errorf("unsupported frame version %d", header.Version)
+	}
+	if header.Lines == 0 || header.Lines > maxFrameLines {
+		return vibration, fmt.Errorf("invalid spectrum length %d", header.Lines)
+	}
+
+	var extension frameHeaderV2
+	if header.Version == frameVersion2 {
+		if err := binary.Read(r, binary.LittleEndian, &extension); err != nil {
+			return vibration, fmt.Errorf("invalid frame header: %v", err)
+		}
+	}
+
+	serial := make([]byte, header.SerialLen)
+	if _, err := io.ReadFull(r, serial); err != nil {
+		return vibration, fmt.Errorf("invalid frame serial number: %v", err)
+	}
+	messageID := make([]byte, extension.MessageIDLen)
+	if _, err := io.ReadFull(r, messageID); err != nil {
+		return vibration, fmt.Errorf("invalid frame message ID: %v", err)
+	}
+
+	vibration.SerialNumber = string(serial)
+	vibration.MessageID = string(messageID)
+	if extension.MeasuredAt != 0 {
+		vibration.MeasuredAt = time.UnixMilli(extension.MeasuredAt).UTC()
+	}
+	vibration.RMSX = float64(header.RMSX)
+	vibration.RMSY = float64(header.RMSY)
+	vibration.RMSZ = float64(header.RMSZ)
+	vibration.PeakX = float64(header.PeakX)
+	vibration.PeakY = float64(header.PeakY)
+	vibration.PeakZ = float64(header.PeakZ)
+
+	axes := []*[]float64{&vibration.FFTX, &vibration.FFTY, &vibration.FFTZ}
+	for _, axis := range axes {
+		values, err := readSamples(r, header.Format, int(header.Lines))
+		if err != nil {
+			return vibration, err
+		}
+		*axis = values
+	}
+
+	return vibration, nil
+}
+
+func readSamples(r io.Reader, format uint8, n int) ([]float64, error) {
+	values := make([]float64, n)
+
+	switch format {
+	case FormatFloat32:
+		raw := make([]float32, n)
+		if err := binary.Read(r, binary.LittleEndian, raw); err != nil {
+			return nil, fmt.Errorf("truncated spectrum: %v", err)
+		}
+		for i, v := range raw {
+			values[i] = float64(v)
+		}
+	case FormatFloat16:
+		raw := make([]uint16, n)
+		if err := binary.Read(r, binary.LittleEndian, raw); err != nil {
+			return nil, fmt.Errorf("truncated spectrum: %v", err)
+		}
+		for i, v := range raw {
+			values[i] = float64(halfToFloat32(v))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sample format %d", format)
+	}
+
+	return values, nil
+}
+
+// EncodeFrame writes a VibrationData as a version 2 binary frame. All axes
+// must have the same length. It is the reference encoder for device firmware.
+func EncodeFrame(vibration models.VibrationData, format uint8) ([]byte, error) {
+	n := len(vibration.FFTX)
+	if n == 0 || n > maxFrameLines || len(vibration.FFTY) != n || len(vibration.FFTZ) != n {
+		return nil, errors.New("all axes must have the same, non-zero length")
+	}
+	if len(vibration.SerialNumber) > math.MaxUint16 {
+		return nil, errors.New("serial number too long")
+	}
+	if len(vibration.MessageID) > math.MaxUint16 {
+		return nil, errors.New("message ID too long")
+	}
+
+	header := frameHeader{
+		Magic:     frameMagic,
+		Version:   frameVersion2,
+		Format:    format,
+		SerialLen: uint16(len(vibration.SerialNumber)),
+		Lines:     uint32(n),
+		RMSX:      float32(vibration.RMSX),
+		RMSY:      float32(vibration.RMSY),
+		RMSZ:      float32(vibration.RMSZ),
+		PeakX:     float32(vibration.PeakX),
+		PeakY:     float32(vibration.PeakY),
+		PeakZ:     float32(vibration.PeakZ),
+	}
+	extension := frameHeaderV2{MessageIDLen: uint16(len(vibration.MessageID))}
+	if !vibration.MeasuredAt.IsZero() {
+		extension.MeasuredAt = vibration.MeasuredAt.UnixMilli()
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	binary.Write(&buf, binary.LittleEndian, extension)
+	buf.WriteString(vibration.SerialNumber)
+	buf.WriteString(vibration.MessageID)
+
+	for _, axis := range [][]float64{vibration.FFTX, vibration.FFTY, vibration.FFTZ} {
+		for _, v := range axis {
+			switch format {
+			case FormatFloat32:
+				binary.Write(&buf, binary.LittleEndian, float32(v))
+			case FormatFloat16:
+				binary.Write(&buf, binary.LittleEndian, float32ToHalf(float32(v)))
+			default:
+				return nil, fmt.Errorf("unsupported sample format %d", format)
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// halfToFloat32 converts an IEEE 754 binary16 value
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// Subnormal: normalise the mantissa
+		exp = 127 - 15 + 1
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		mant &= 0x3ff
+		return math.Float32frombits(sign | exp<<23 | mant<<13)
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | mant<<13)
+	default:
+		return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+	}
+}
+
+// float32ToHalf converts to IEEE 754 binary16, rounding to nearest even
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32(bits>>23&0xff) - 127 + 15
+	mant := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff == 0:
+		return sign
+	case bits>>23&0xff == 0xff:
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		half := mant >> shift
+		if rem := mant & (1<<shift - 1); rem > 1<<(shift-1) || (rem == 1<<(shift-1) && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+
+	half := uint32(exp)<<10 | mant>>13
+	if rem := mant & 0x1fff; rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++
+	}
+	return sign | uint16(half)
+}
@@ -0,0 +1,261 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+)
+
+func testVibration() models.VibrationData {
+	return models.VibrationData{
+		SerialNumber: "SN-001",
+		MessageID:    "msg-42",
+		MeasuredAt:   time.UnixMilli(1700000000123).UTC(),
+		RMSX:         0.5,
+		RMSY:         0.25,
+		RMSZ:         0.125,
+		PeakX:        1.5,
+		PeakY:        1.25,
+		PeakZ:        1.125,
+		FFTX:         []float64{0, 0.5, 1, 2},
+		FFTY:         []float64{0.25, 0.75, 1.5, 3},
+		FFTZ:         []float64{-1, -0.5, 0.125, 4},
+	}
+}
+
+// encodeV1 builds a version 1 frame, which EncodeFrame no longer writes
+func encodeV1(vibration models.VibrationData) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, frameHeader{
+		Magic:     frameMagic,
+		Version:   frameVersion1,
+		Format:    FormatFloat32,
+		SerialLen: uint16(len(vibration.SerialNumber)),
+		Lines:     uint32(len(vibration.FFTX)),
+		RMSX:      float32(vibration.RMSX),
+		RMSY:      float32(vibration.RMSY),
+		RMSZ:      float32(vibration.RMSZ),
+		PeakX:     float32(vibration.PeakX),
+		PeakY:     float32(vibration.PeakY),
+		PeakZ:     float32(vibration.PeakZ),
+	})
+	buf.WriteString(vibration.SerialNumber)
+	for _, axis := range [][]float64{vibration.FFTX, vibration.FFTY, vibration.FFTZ} {
+		for _, v := range axis {
+			binary.Write(&buf, binary.LittleEndian, float32(v))
+		}
+	}
+	return buf.Bytes()
+}
+
+func assertSamples(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d samples, want %d", name, len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s[%d] = %v, want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		format uint8
+		edit   func(*models.VibrationData)
+	}{
+		{"float32", FormatFloat32, nil},
+		{"float16", FormatFloat16, nil},
+		{"no timestamp or message ID", FormatFloat32, func(v *models.VibrationData) {
+			v.MeasuredAt = time.Time{}
+			v.MessageID = ""
+		}},
+		{"serial number from URL", FormatFloat32, func(v *models.VibrationData) {
+			v.SerialNumber = ""
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := testVibration()
+			if tt.edit != nil {
+				tt.edit(&want)
+			}
+
+			frame, err := EncodeFrame(want, tt.format)
+			if err != nil {
+				t.Fatalf("EncodeFrame: %v", err)
+			}
+			got, err := DecodeFrame(bytes.NewReader(frame))
+			if err != nil {
+				t.Fatalf("DecodeFrame: %v", err)
+			}
+
+			if got.SerialNumber != want.SerialNumber || got.MessageID != want.MessageID {
+				t.Errorf("got serial %q message ID %q, want %q %q", got.SerialNumber, got.MessageID, want.SerialNumber, want.MessageID)
+			}
+			if !got.MeasuredAt.Equal(want.MeasuredAt) {
+				t.Errorf("MeasuredAt = %v, want %v", got.MeasuredAt, want.MeasuredAt)
+			}
+			if got.RMSX != want.RMSX || got.RMSY != want.RMSY || got.RMSZ != want.RMSZ ||
+				got.PeakX != want.PeakX || got.PeakY != want.PeakY || got.PeakZ != want.PeakZ {
+				t.Errorf("RMS and peaks = %+v, want %+v", got, want)
+			}
+			// The test samples are exact in both formats
+			assertSamples(t, "FFTX", got.FFTX, want.FFTX)
+			assertSamples(t, "FFTY", got.FFTY, want.FFTY)
+			assertSamples(t, "FFTZ", got.FFTZ, want.FFTZ)
+		})
+	}
+}
+
+func TestDecodeFrameVersion1(t *testing.T) {
+	want := testVibration()
+	got, err := DecodeFrame(bytes.NewReader(encodeV1(want)))
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	if got.SerialNumber != want.SerialNumber {
+		t.Errorf("SerialNumber = %q, want %q", got.SerialNumber, want.SerialNumber)
+	}
+	if got.MessageID != "" || !got.MeasuredAt.IsZero() {
+		t.Errorf("version 1 frame decoded message ID %q and time %v", got.MessageID, got.MeasuredAt)
+	}
+	assertSamples(t, "FFTX", got.FFTX, want.FFTX)
+	assertSamples(t, "FFTZ", got.FFTZ, want.FFTZ)
+}
+
+func TestDecodeFrameMalformed(t *testing.T) {
+	frame, err := EncodeFrame(testVibration(), FormatFloat32)
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+	// Offsets from the layout in frameHeader's comment
+	const (
+		headerEnd    = 36
+		extensionEnd = 46
+		serialEnd    = extensionEnd + len("SN-001")
+		messageIDEnd = serialEnd + len("msg-42")
+	)
+
+	withByte := func(offset int, value byte) []byte {
+		out := append([]byte(nil), frame...)
+		out[offset] = value
+		return out
+	}
+	withLines := func(lines uint32) []byte {
+		out := append([]byte(nil), frame...)
+		binary.LittleEndian.PutUint32(out[8:], lines)
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		err   string
+	}{
+		{"empty", nil, "invalid frame header"},
+		{"truncated header", frame[:headerEnd-1], "invalid frame header"},
+		{"bad magic", withByte(0, 'X'), "invalid frame magic"},
+		{"unknown version", withByte(4, 3), "unsupported frame version 3"},
+		{"zero lines", withLines(0), "invalid spectrum length 0"},
+		{"too many lines", withLines(maxFrameLines + 1), "invalid spectrum length"},
+		{"truncated extension", frame[:extensionEnd-1], "invalid frame header"},
+		{"truncated serial number", frame[:serialEnd-1], "invalid frame serial number"},
+		{"truncated message ID", frame[:messageIDEnd-1], "invalid frame message ID"},
+		{"truncated samples", frame[:len(frame)-1], "truncated spectrum"},
+		{"unknown sample format", withByte(5, 9), "unsupported sample format 9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeFrame(bytes.NewReader(tt.frame))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("DecodeFrame error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEncodeFrameInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format uint8
+		edit   func(*models.VibrationData)
+	}{
+		{"no samples", FormatFloat32, func(v *models.VibrationData) { v.FFTX, v.FFTY, v.FFTZ = nil, nil, nil }},
+		{"axes of different length", FormatFloat32, func(v *models.VibrationData) { v.FFTY = v.FFTY[:2] }},
+		{"serial number too long", FormatFloat32, func(v *models.VibrationData) { v.SerialNumber = strings.Repeat("s", math.MaxUint16+1) }},
+		{"unknown sample format", 9, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vibration := testVibration()
+			if tt.edit != nil {
+				tt.edit(&vibration)
+			}
+			if _, err := EncodeFrame(vibration, tt.format); err == nil {
+				t.Error("EncodeFrame succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	tests := []struct {
+		name string
+		f    float32
+		half uint16
+	}{
+		{"zero", 0, 0x0000},
+		{"negative zero", float32(math.Copysign(0, -1)), 0x8000},
+		{"one", 1, 0x3c00},
+		{"minus two", -2, 0xc000},
+		{"one third", 1.0 / 3, 0x3555},
+		{"largest normal", 65504, 0x7bff},
+		{"overflow", 1e6, 0x7c00},
+		{"negative overflow", -1e6, 0xfc00},
+		{"infinity", float32(math.Inf(1)), 0x7c00},
+		{"smallest normal", 6.103515625e-05, 0x0400},
+		{"smallest subnormal", 5.960464477539063e-08, 0x0001},
+		{"underflow", 1e-9, 0x0000},
+		{"ties to even rounds down", 1 + 1.0/2048, 0x3c00},
+		{"ties to even rounds up", 1 + 3.0/2048, 0x3c02},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := float32ToHalf(tt.f); got != tt.half {
+				t.Errorf("float32ToHalf(%v) = %#04x, want %#04x", tt.f, got, tt.half)
+			}
+		})
+	}
+
+	if got := float32ToHalf(float32(math.NaN())); got != 0x7e00 {
+		t.Errorf("float32ToHalf(NaN) = %#04x, want 0x7e00", got)
+	}
+	if got := halfToFloat32(0x7e00); !math.IsNaN(float64(got)) {
+		t.Errorf("halfToFloat32(0x7e00) = %v, want NaN", got)
+	}
+}
+
+func TestFloat16RoundTrip(t *testing.T) {
+	// Every finite half converts to float32 and back unchanged
+	for h := 0; h <= math.MaxUint16; h++ {
+		half := uint16(h)
+		if half&0x7c00 == 0x7c00 && half&0x3ff != 0 {
+			continue // NaN payloads are not kept
+		}
+		if got := float32ToHalf(halfToFloat32(half)); got != half {
+			t.Fatalf("round trip of %#04x gave %#04x", half, got)
+		}
+	}
+}
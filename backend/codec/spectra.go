@@ -0,0 +1,84 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SpectraEncoding names the format written by EncodeSpectra
+const SpectraEncoding = "vibs-f64-zstd"
+
+var spectraMagic = [4]byte{'V', 'I', 'B', 'S'}
+
+// Largest number of values accepted per array when decoding
+const maxSpectraValues = 1 << 24
+
+// EncodeSpectra packs arrays of float64 into a zstd compressed blob. Values
+// are stored losslessly. Before compression the layout is, little-endian:
+//
+//	magic "VIBS", uint32 array count, then per array a uint32 length
+//	followed by that many float64 values
+func EncodeSpectra(arrays [][]float64) ([]byte, error) {
+	var raw bytes.Buffer
+	raw.Write(spectraMagic[:])
+	binary.Write(&raw, binary.LittleEndian, uint32(len(arrays)))
+	for _, values := range arrays {
+		binary.Write(&raw, binary.LittleEndian, uint32(len(values)))
+		binary.Write(&raw, binary.LittleEndian, values)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(raw.Bytes(), nil), nil
+}
+
+// DecodeSpectra unpacks a blob written by EncodeSpectra
+func DecodeSpectra(data []byte) ([][]float64, error) {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+
+	raw, err := decoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spectra blob: %v", err)
+	}
+
+	r := bytes.NewReader(raw)
+	var magic [4]byte
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil || magic != spectraMagic {
+		return nil, errors.New("invalid spectra blob: bad magic")
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, errors.New("invalid spectra blob: truncated")
+	}
+
+	arrays := make([][]float64, count)
+	for i := range arrays {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, errors.New("invalid spectra blob: truncated")
+		}
+		if n > maxSpectraValues || int(n)*8 > r.Len() {
+			return nil, errors.New("invalid spectra blob: bad array length")
+		}
+		values := make([]float64, n)
+		for k := range values {
+			var bits uint64
+			binary.Read(r, binary.LittleEndian, &bits)
+			values[k] = math.Float64frombits(bits)
+		}
+		arrays[i] = values
+	}
+	return arrays, nil
+}
@@ -2,10 +2,109 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	JWTSecret string
+
+	// SMTP settings for email notifications
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Generic HTTP SMS gateway settings
+	SMSGatewayURL   string
+	SMSGatewayToken string
+
+	// When set, notifications are written to this file instead of being delivered
+	NotificationSinkFile string
+
+	// How often unacknowledged alarms are checked for escalation; 0 disables
+	// escalation
+	EscalationInterval time.Duration
+
+	// MQTT ingestion bridge; disabled when MQTTBrokerURL is empty.
+	// Topics may contain {serial}, which matches one topic level.
+	MQTTBrokerURL    string
+	MQTTClientID     string
+	MQTTUsername     string
+	MQTTPassword     string
+	MQTTTopicPattern string
+	MQTTAckTopic     string
+	MQTTErrorTopic   string
+	MQTTQoS          int
+
+	// Largest ingest body accepted after decompression, and the larger limit
+	// for NDJSON backfill streams
+	MaxIngestBodyBytes   int64
+	MaxBackfillBodyBytes int64
+
+	// Default anomaly score at which a reading raises a warning
+	AnomalyThreshold float64
+
+	// How often retention policies are applied; 0 disables compaction
+	RetentionInterval time.Duration
+
+	// Create vibrations as a time-series collection (MongoDB 5.0+) with the
+	// given bucket granularity: seconds, minutes or hours
+	VibrationsTimeSeries  bool
+	TimeSeriesGranularity string
+
+	// Object storage for large spectra: "" keeps them inline, "file" uses
+	// BlobDir and "s3" any S3-compatible service such as MinIO
+	BlobStore          string
+	BlobDir            string
+	BlobThresholdBytes int
+	S3Endpoint         string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3Bucket           string
+	S3Region           string
+	S3UseSSL           bool
+
+	// How long ingest idempotency keys are remembered
+	IdempotencyTTL time.Duration
+
+	// Accepted window for device timestamps around the receive time. Readings
+	// outside it are flagged; those from the future are stamped with the
+	// receive time. A zero MaxReadingAge accepts any past timestamp.
+	ClockSkewFuture time.Duration
+	MaxReadingAge   time.Duration
+
+	// Ingest rate limits in readings per minute, per sensor unless the sensor
+	// sets its own quota, and per organization; 0 disables a limit. Buckets
+	// hold RateLimitBurst worth of readings. RateLimitBackend is "memory" for
+	// one instance or "mongo" to share buckets between instances.
+	SensorReadingsPerMinute int
+	OrgReadingsPerMinute    int
+	RateLimitBurst          time.Duration
+	RateLimitBackend        string
+
+	// IngestMode "sync" stores each reading before responding; "async"
+	// validates it, queues it and answers 202 Accepted. Queued readings are
+	// stored by IngestWorkers workers in batches of up to IngestBatchSize,
+	// waiting at most IngestBatchWait to fill one. On SIGTERM the queue is
+	// drained for up to ShutdownTimeout before the process exits.
+	IngestMode      string
+	IngestQueueSize int
+	IngestWorkers   int
+	IngestBatchSize int
+	IngestBatchWait time.Duration
+	ShutdownTimeout time.Duration
+
+	// Sensors cached for ingest lookups by serial number and API key; a zero
+	// size disables the cache
+	SensorCacheSize int
+	SensorCacheTTL  time.Duration
+
+	// Origins, such as https://dashboard.example.com, whose pages may open
+	// stream WebSockets besides the server's own
+	AllowedOrigins []string
 }
 
 var appConfig *Config
@@ -13,6 +112,70 @@ var appConfig *Config
 func init() {
 	appConfig = &Config{
 		JWTSecret: getEnv("JWT_SECRET", "your-secret-key"), // Default secret key, should be changed in production
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnvInt("SMTP_PORT", 587),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "alarms@vibration-sensor.local"),
+
+		SMSGatewayURL:   getEnv("SMS_GATEWAY_URL", ""),
+		SMSGatewayToken: getEnv("SMS_GATEWAY_TOKEN", ""),
+
+		NotificationSinkFile: getEnv("NOTIFICATION_SINK_FILE", ""),
+
+		EscalationInterval: time.Duration(getEnvInt("ESCALATION_INTERVAL_SECONDS", 30)) * time.Second,
+
+		MQTTBrokerURL:    getEnv("MQTT_BROKER_URL", ""),
+		MQTTClientID:     getEnv("MQTT_CLIENT_ID", "vibration-backend"),
+		MQTTUsername:     getEnv("MQTT_USERNAME", ""),
+		MQTTPassword:     getEnv("MQTT_PASSWORD", ""),
+		MQTTTopicPattern: getEnv("MQTT_TOPIC_PATTERN", "sensors/{serial}/vibration"),
+		MQTTAckTopic:     getEnv("MQTT_ACK_TOPIC", "sensors/{serial}/vibration/ack"),
+		MQTTErrorTopic:   getEnv("MQTT_ERROR_TOPIC", "sensors/{serial}/vibration/error"),
+		MQTTQoS:          getEnvInt("MQTT_QOS", 1),
+
+		MaxIngestBodyBytes:   int64(getEnvInt("MAX_INGEST_BODY_BYTES", 16<<20)),
+		MaxBackfillBodyBytes: int64(getEnvInt("MAX_BACKFILL_BODY_BYTES", 512<<20)),
+
+		AnomalyThreshold: getEnvFloat("ANOMALY_THRESHOLD", 4),
+
+		RetentionInterval: time.Duration(getEnvInt("RETENTION_INTERVAL_MINUTES", 60)) * time.Minute,
+
+		VibrationsTimeSeries:  getEnv("VIBRATIONS_TIMESERIES", "false") == "true",
+		TimeSeriesGranularity: getEnv("TIMESERIES_GRANULARITY", "seconds"),
+
+		BlobStore:          getEnv("BLOB_STORE", ""),
+		BlobDir:            getEnv("BLOB_DIR", "./blobs"),
+		BlobThresholdBytes: getEnvInt("BLOB_THRESHOLD_BYTES", 64<<10),
+		S3Endpoint:         getEnv("S3_ENDPOINT", "localhost:9000"),
+		S3AccessKey:        getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:        getEnv("S3_SECRET_KEY", ""),
+		S3Bucket:           getEnv("S3_BUCKET", "vibration-spectra"),
+		S3Region:           getEnv("S3_REGION", ""),
+		S3UseSSL:           getEnv("S3_USE_SSL", "false") == "true",
+
+		IdempotencyTTL: time.Duration(getEnvInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour,
+
+		ClockSkewFuture: time.Duration(getEnvInt("CLOCK_SKEW_FUTURE_SECONDS", 300)) * time.Second,
+		MaxReadingAge:   time.Duration(getEnvInt("MAX_READING_AGE_HOURS", 168)) * time.Hour,
+
+		SensorReadingsPerMinute: getEnvInt("SENSOR_READINGS_PER_MINUTE", 600),
+		OrgReadingsPerMinute:    getEnvInt("ORG_READINGS_PER_MINUTE", 6000),
+		RateLimitBurst:          time.Duration(getEnvInt("RATE_LIMIT_BURST_SECONDS", 10)) * time.Second,
+		RateLimitBackend:        getEnv("RATE_LIMIT_BACKEND", "memory"),
+
+		IngestMode:      getEnv("INGEST_MODE", "sync"),
+		IngestQueueSize: getEnvInt("INGEST_QUEUE_SIZE", 10000),
+		IngestWorkers:   getEnvInt("INGEST_WORKERS", 4),
+		IngestBatchSize: getEnvInt("INGEST_BATCH_SIZE", 200),
+		IngestBatchWait: time.Duration(getEnvInt("INGEST_BATCH_WAIT_MS", 50)) * time.Millisecond,
+		ShutdownTimeout: time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second,
+
+		SensorCacheSize: getEnvInt("SENSOR_CACHE_SIZE", 10000),
+		SensorCacheTTL:  time.Duration(getEnvInt("SENSOR_CACHE_TTL_SECONDS", 60)) * time.Second,
+
+		AllowedOrigins: getEnvList("ALLOWED_ORIGINS"),
 	}
 }
 
@@ -26,3 +189,32 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList reads a comma-separated list, leaving out empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
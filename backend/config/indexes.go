@@ -0,0 +1,142 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes lists the indexes each collection needs. Lists are paged by
+// (created_at, _id), and readings by (measured_at, _id), so those keys back
+// every list query.
+var indexes = map[string][]mongo.IndexModel{
+	"vibrations": {
+		{Keys: bson.D{{Key: "serial_number", Value: 1}, {Key: "measured_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "measured_at", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+	"baselines": {
+		{Keys: bson.D{{Key: "sensor_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"retention_policies": {
+		{Keys: bson.D{{Key: "organization", Value: 1}}, Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"organization": bson.M{"$exists": true}})},
+		{Keys: bson.D{{Key: "serial_number", Value: 1}}, Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"serial_number": bson.M{"$exists": true}})},
+	},
+	"retention_state": {
+		{Keys: bson.D{{Key: "serial_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"vibration_rollups": {
+		{Keys: bson.D{{Key: "serial_number", Value: 1}, {Key: "interval", Value: 1}, {Key: "time", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"ingest_keys": {
+		{Keys: bson.D{{Key: "serial_number", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"alarms": {
+		{Keys: bson.D{{Key: "vibration_id", Value: 1}}},
+	},
+	"sensors": {
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+}
+
+// uniqueFields lists the fields that identify documents but that earlier
+// versions did not keep unique, so existing databases may hold duplicates.
+// Empty values are left out of the index.
+var uniqueFields = map[string]string{
+	"sensors": "serial_number",
+	"users":   "username",
+}
+
+// Duplicate values listed when a unique index cannot be built
+const maxListedDuplicates = 20
+
+// ttlIndexes lists the indexes whose expiry comes from the configuration.
+// Changing the expiry later requires dropping the existing index first.
+func ttlIndexes() map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		"ingest_keys": {
+			{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().
+				SetExpireAfterSeconds(int32(GetConfig().IdempotencyTTL.Seconds()))},
+		},
+		"stream_tickets": {
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+}
+
+// EnsureIndexes creates missing indexes; existing ones are left untouched
+func EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, set := range []map[string][]mongo.IndexModel{indexes, ttlIndexes()} {
+		for name, models := range set {
+			if _, err := GetCollection(name).Indexes().CreateMany(ctx, models); err != nil {
+				return fmt.Errorf("failed to create indexes on %s: %v", name, err)
+			}
+		}
+	}
+	for name, field := range uniqueFields {
+		if err := ensureUniqueIndex(ctx, name, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureUniqueIndex creates a unique index on field. When existing documents
+// share a value, the index is skipped and the conflicting documents are
+// logged, so the server still starts; it is created on the first start after
+// the duplicates are removed.
+func ensureUniqueIndex(ctx context.Context, name, field string) error {
+	collection := GetCollection(name)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{field: bson.M{"$gt": ""}}),
+	})
+	if err == nil {
+		return nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to create unique index on %s.%s: %v", name, field, err)
+	}
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{field: bson.M{"$gt": ""}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + field, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$limit", Value: maxListedDuplicates}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find duplicate %s in %s: %v", field, name, err)
+	}
+	var duplicates []struct {
+		Value string               `bson:"_id"`
+		IDs   []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("failed to find duplicate %s in %s: %v", field, name, err)
+	}
+
+	listed := make([]string, len(duplicates))
+	for i, duplicate := range duplicates {
+		ids := make([]string, len(duplicate.IDs))
+		for j, id := range duplicate.IDs {
+			ids[j] = id.Hex()
+		}
+		listed[i] = fmt.Sprintf("%q (%s)", duplicate.Value, strings.Join(ids, ", "))
+	}
+	log.Printf("Not enforcing unique %s.%s, these values are used more than once: %s. Remove the duplicates and restart to create the index.",
+		name, field, strings.Join(listed, "; "))
+	return nil
+}
@@ -36,6 +36,9 @@ func ConnectDB() error {
 	return nil
 }
 
+// Database holding all collections
+const databaseName = "vibration-sensor"
+
 func GetCollection(collectionName string) *mongo.Collection {
-	return Client.Database("vibration-sensor").Collection(collectionName)
+	return Client.Database(databaseName).Collection(collectionName)
 }
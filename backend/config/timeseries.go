@@ -0,0 +1,68 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Time-series bucket granularities accepted by MongoDB
+var timeSeriesGranularities = map[string]bool{"seconds": true, "minutes": true, "hours": true}
+
+// CreateVibrationsTimeSeries creates name as a time-series collection with
+// created_at as the time field and serial_number as the meta field
+func CreateVibrationsTimeSeries(ctx context.Context, name, granularity string) error {
+	if !timeSeriesGranularities[granularity] {
+		return fmt.Errorf("granularity must be seconds, minutes or hours")
+	}
+
+	opts := options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().
+		SetTimeField("created_at").
+		SetMetaField("serial_number").
+		SetGranularity(granularity))
+	return Client.Database(databaseName).CreateCollection(ctx, name, opts)
+}
+
+// CollectionType returns "collection", "timeseries" or "view", or "" when the
+// collection does not exist
+func CollectionType(ctx context.Context, name string) (string, error) {
+	specs, err := Client.Database(databaseName).ListCollectionSpecifications(ctx, bson.M{"name": name})
+	if err != nil {
+		return "", err
+	}
+	if len(specs) == 0 {
+		return "", nil
+	}
+	return specs[0].Type, nil
+}
+
+// EnsureVibrationsCollection creates the vibrations collection as a
+// time-series collection when enabled and it does not exist yet. An existing
+// regular collection is left alone; cmd/migrate-timeseries converts it.
+func EnsureVibrationsCollection() error {
+	cfg := GetConfig()
+	if !cfg.VibrationsTimeSeries {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	kind, err := CollectionType(ctx, "vibrations")
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case "":
+		return CreateVibrationsTimeSeries(ctx, "vibrations", cfg.TimeSeriesGranularity)
+	case "timeseries":
+		return nil
+	default:
+		log.Printf("vibrations is a regular collection; run cmd/migrate-timeseries to convert it")
+		return nil
+	}
+}
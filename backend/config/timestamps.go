@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BackfillTimestamps gives readings stored before device timestamps were kept
+// a measured_at and received_at equal to their created_at, and readings stored
+// without created_at one equal to their measured_at. It walks the readings in
+// _id order, updating batchSize at a time, and returns how many it updated.
+func BackfillTimestamps(ctx context.Context, name string, batchSize int) (int64, error) {
+	collection := GetCollection(name)
+	filter := bson.M{"$or": bson.A{
+		bson.M{"measured_at": bson.M{"$exists": false}},
+		bson.M{"created_at": bson.M{"$exists": false}},
+	}}
+	update := bson.A{bson.M{"$set": bson.M{
+		"measured_at": bson.M{"$ifNull": bson.A{"$measured_at", "$created_at"}},
+		"received_at": bson.M{"$ifNull": bson.A{"$received_at", "$created_at", "$measured_at"}},
+		"created_at":  bson.M{"$ifNull": bson.A{"$created_at", "$measured_at"}},
+	}}}
+
+	var updated int64
+	var last interface{}
+	for {
+		if last != nil {
+			filter["_id"] = bson.M{"$gt": last}
+		}
+		cursor, err := collection.Find(ctx, filter, options.Find().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetProjection(bson.M{"_id": 1}).
+			SetLimit(int64(batchSize)))
+		if err != nil {
+			return updated, err
+		}
+		var docs []struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return updated, err
+		}
+		if len(docs) == 0 {
+			return updated, nil
+		}
+
+		ids := make(bson.A, len(docs))
+		for i, doc := range docs {
+			ids[i] = doc.ID
+		}
+		last = docs[len(docs)-1].ID
+		result, err := collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update)
+		if err != nil {
+			return updated, err
+		}
+		updated += result.ModifiedCount
+	}
+}
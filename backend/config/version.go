@@ -0,0 +1,41 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	versionMu    sync.Mutex
+	versionMajor int
+)
+
+// ServerMajorVersion returns the major version of the MongoDB server, read
+// with buildInfo on first use
+func ServerMajorVersion() (int, error) {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+	if versionMajor > 0 {
+		return versionMajor, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var info struct {
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	err := Client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+	if err != nil {
+		return 0, err
+	}
+	if len(info.VersionArray) == 0 {
+		return 0, errors.New("buildInfo returned no version")
+	}
+	versionMajor = int(info.VersionArray[0])
+	return versionMajor, nil
+}
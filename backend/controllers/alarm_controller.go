@@ -0,0 +1,576 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/notifications"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fractions of Sensor.AlarmThs at which each warning level starts
+const (
+	warningRatio  = 0.6
+	criticalRatio = 0.8
+)
+
+// How long an escalation step that reached nobody waits before it is tried
+// again
+const escalationRetryDelay = 5 * time.Minute
+
+// alarmQueues holds each sensor's readings waiting for alarm evaluation.
+// A sensor with an entry has a goroutine working through it, so its readings
+// are evaluated one at a time in the order they were stored.
+var alarmQueues = struct {
+	sync.Mutex
+	pending map[primitive.ObjectID][]func()
+}{pending: map[primitive.ObjectID][]func(){}}
+
+// evaluateWarningLevel maps the highest axis RMS against the sensor's alarm
+// threshold, raised to the anomaly level when the reading deviates from the
+// sensor's baseline
+func evaluateWarningLevel(sensor models.Sensor, vibration models.VibrationData) int {
+	anomaly := anomalyLevel(sensor, vibration)
+	if sensor.AlarmThs <= 0 {
+		return anomaly
+	}
+
+	rms := math.Max(vibration.RMSX, math.Max(vibration.RMSY, vibration.RMSZ))
+	ratio := rms / sensor.AlarmThs
+
+	level := 1
+	switch {
+	case ratio >= 1:
+		level = 4
+	case ratio >= criticalRatio:
+		level = 3
+	case ratio >= warningRatio:
+		level = 2
+	}
+	if anomaly > level {
+		return anomaly
+	}
+	return level
+}
+
+// evaluateAlarm drives the alarm lifecycle for a stored reading in the background.
+// A reading above Normal opens an alarm or raises the level of the active one,
+// and a Normal reading resolves the active alarm.
+// Readings suppressed by a maintenance window are ignored.
+// A sensor's readings are evaluated in the order evaluateAlarm is called.
+func evaluateAlarm(sensor models.Sensor, vibration models.VibrationData) {
+	if vibration.Suppressed {
+		return
+	}
+
+	level := evaluateWarningLevel(sensor, vibration)
+	evaluate := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := applyAlarmLevel(ctx, sensor, vibration, level); err != nil {
+			log.Printf("Alarm error - Sensor %s: %v", sensor.SerialNumber, err)
+		}
+	}
+
+	alarmQueues.Lock()
+	queue, running := alarmQueues.pending[sensor.ID]
+	alarmQueues.pending[sensor.ID] = append(queue, evaluate)
+	alarmQueues.Unlock()
+	if !running {
+		go drainAlarmQueue(sensor.ID)
+	}
+}
+
+// drainAlarmQueue evaluates a sensor's queued readings until none are left
+func drainAlarmQueue(sensorID primitive.ObjectID) {
+	for {
+		alarmQueues.Lock()
+		queue := alarmQueues.pending[sensorID]
+		if len(queue) == 0 {
+			delete(alarmQueues.pending, sensorID)
+			alarmQueues.Unlock()
+			return
+		}
+		evaluate := queue[0]
+		alarmQueues.pending[sensorID] = queue[1:]
+		alarmQueues.Unlock()
+
+		evaluate()
+	}
+}
+
+func applyAlarmLevel(ctx context.Context, sensor models.Sensor, vibration models.VibrationData, level int) error {
+	collection := config.GetCollection("alarms")
+
+	var alarm models.Alarm
+	err := collection.FindOne(ctx, bson.M{
+		"sensor_id": sensor.ID,
+		"status":    bson.M{"$in": []string{models.AlarmStatusOpen, models.AlarmStatusAcknowledged}},
+	}).Decode(&alarm)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	active := err == nil
+
+	var owner models.User
+	if err := config.GetCollection("users").FindOne(ctx, bson.M{"_id": sensor.UserID}).Decode(&owner); err != nil {
+		return err
+	}
+
+	alert := buildAlert(sensor, vibration, level)
+
+	switch {
+	case !active && level > 1:
+		now := time.Now()
+		alarm = models.Alarm{
+			SensorID:     sensor.ID,
+			SerialNumber: sensor.SerialNumber,
+			Location:     sensor.Location,
+			Organization: owner.Organization,
+			VibrationID:  vibration.ID,
+			Level:        level,
+			Status:       models.AlarmStatusOpen,
+			TriggeredAt:  now,
+			Escalations:  []models.EscalationEvent{},
+		}
+		attachEscalationPolicy(ctx, &alarm, now)
+
+		result, err := collection.InsertOne(ctx, alarm)
+		if err != nil {
+			return err
+		}
+		alarm.ID = result.InsertedID.(primitive.ObjectID)
+		publishAlarm(alarm)
+
+		return recordEscalation(ctx, alarm.ID, notifyUsers(ctx, []models.User{owner}, alert, 0))
+
+	case active && level == 1:
+		now := time.Now()
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": alarm.ID}, bson.M{
+			"$set":   bson.M{"status": models.AlarmStatusResolved, "resolved_at": now},
+			"$unset": bson.M{"next_escalation_at": ""},
+		})
+		if err != nil {
+			return err
+		}
+		alarm.Status = models.AlarmStatusResolved
+		alarm.ResolvedAt = &now
+		alarm.NextEscalationAt = nil
+		publishAlarm(alarm)
+
+		notifyUsers(ctx, []models.User{owner}, alert, 0)
+		return nil
+
+	case active && level > alarm.Level:
+		alarm.Level = level
+		set := bson.M{"level": level}
+		if alarm.EscalationPolicyID == nil && alarm.Status == models.AlarmStatusOpen {
+			attachEscalationPolicy(ctx, &alarm, time.Now())
+			if alarm.EscalationPolicyID != nil {
+				set["escalation_policy_id"] = alarm.EscalationPolicyID
+				set["next_escalation_at"] = alarm.NextEscalationAt
+			}
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": alarm.ID}, bson.M{"$set": set}); err != nil {
+			return err
+		}
+		publishAlarm(alarm)
+
+		return recordEscalation(ctx, alarm.ID, notifyUsers(ctx, []models.User{owner}, alert, 0))
+	}
+
+	return nil
+}
+
+// attachEscalationPolicy assigns the organization's policy for the alarm level, if any
+func attachEscalationPolicy(ctx context.Context, alarm *models.Alarm, now time.Time) {
+	var policy models.EscalationPolicy
+	opts := options.FindOne().SetSort(bson.D{{Key: "min_level", Value: -1}})
+	err := config.GetCollection("escalation_policies").FindOne(ctx, bson.M{
+		"organization": alarm.Organization,
+		"min_level":    bson.M{"$lte": alarm.Level},
+	}, opts).Decode(&policy)
+	if err != nil || len(policy.Steps) == 0 {
+		return
+	}
+
+	next := now.Add(time.Duration(policy.Steps[0].DelayMinutes) * time.Minute)
+	alarm.EscalationPolicyID = &policy.ID
+	alarm.EscalationStep = 0
+	alarm.NextEscalationAt = &next
+}
+
+func buildAlert(sensor models.Sensor, vibration models.VibrationData, level int) notifications.Alert {
+	return notifications.Alert{
+		Level:        level,
+		LevelName:    defaultWarnings[level-1].Name,
+		SerialNumber: sensor.SerialNumber,
+		Location:     sensor.Location,
+		RMSX:         vibration.RMSX,
+		RMSY:         vibration.RMSY,
+		RMSZ:         vibration.RMSZ,
+		AlarmThs:     sensor.AlarmThs,
+		Time:         vibration.MeasuredAt,
+	}
+}
+
+// notifyUsers sends an alert to each user and returns the resulting escalation event
+func notifyUsers(ctx context.Context, users []models.User, alert notifications.Alert, step int) models.EscalationEvent {
+	event := models.EscalationEvent{
+		Step:       step,
+		UserIDs:    []primitive.ObjectID{},
+		Channels:   []string{},
+		NotifiedAt: time.Now(),
+	}
+
+	for _, user := range users {
+		event.UserIDs = append(event.UserIDs, user.ID)
+
+		sent, err := notifications.Notify(ctx, user, alert)
+		if err != nil {
+			log.Printf("Notification error - User %s: %v", user.ID.Hex(), err)
+		}
+		for _, msg := range sent {
+			event.Channels = append(event.Channels, string(msg.Channel))
+		}
+	}
+
+	return event
+}
+
+func recordEscalation(ctx context.Context, alarmID primitive.ObjectID, event models.EscalationEvent) error {
+	_, err := config.GetCollection("alarms").UpdateOne(ctx,
+		bson.M{"_id": alarmID},
+		bson.M{"$push": bson.M{"escalations": event}},
+	)
+	return err
+}
+
+// StartEscalationScheduler periodically escalates unacknowledged alarms
+func StartEscalationScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := runEscalations(ctx, time.Now()); err != nil {
+			log.Printf("Escalation error: %v", err)
+		}
+		cancel()
+	}
+}
+
+func runEscalations(ctx context.Context, now time.Time) error {
+	collection := config.GetCollection("alarms")
+	cursor, err := collection.Find(ctx, bson.M{
+		"status":             models.AlarmStatusOpen,
+		"next_escalation_at": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var alarms []models.Alarm
+	if err := cursor.All(ctx, &alarms); err != nil {
+		return err
+	}
+
+	for _, alarm := range alarms {
+		if err := escalateAlarm(ctx, alarm, now); err != nil {
+			log.Printf("Escalation error - Alarm %s: %v", alarm.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+func escalateAlarm(ctx context.Context, alarm models.Alarm, now time.Time) error {
+	collection := config.GetCollection("alarms")
+
+	var policy models.EscalationPolicy
+	if alarm.EscalationPolicyID != nil {
+		err := config.GetCollection("escalation_policies").FindOne(ctx, bson.M{"_id": alarm.EscalationPolicyID}).Decode(&policy)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+	}
+
+	// Policy removed or exhausted: stop escalating
+	if alarm.EscalationStep >= len(policy.Steps) {
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": alarm.ID}, bson.M{"$unset": bson.M{"next_escalation_at": ""}})
+		return err
+	}
+
+	// A deleted sensor is still escalated under the alarm's serial number
+	var sensor models.Sensor
+	err := config.GetCollection("sensors").FindOne(ctx, bson.M{"_id": alarm.SensorID}).Decode(&sensor)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	sensor.SerialNumber = alarm.SerialNumber
+
+	// A deleted reading leaves the alert without its measurements
+	var vibration models.VibrationData
+	err = config.GetCollection("vibrations").FindOne(ctx, bson.M{"_id": alarm.VibrationID}).Decode(&vibration)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	// Hold escalation while the sensor is under maintenance
+	window, err := activeMaintenanceWindow(ctx, sensor, now)
+	if err != nil || window != nil {
+		return err
+	}
+
+	// Find who to notify before using up the step, so that a step that
+	// reaches nobody is tried again instead of skipped
+	step := policy.Steps[alarm.EscalationStep]
+	users, err := resolveEscalationTargets(ctx, step, now)
+	if err != nil {
+		return retryEscalation(ctx, alarm, now, "failed to find the users to notify: "+err.Error())
+	}
+	if len(users) == 0 {
+		return retryEscalation(ctx, alarm, now, "nobody is on call")
+	}
+
+	update := bson.M{"$set": bson.M{"escalation_step": alarm.EscalationStep + 1}}
+	if alarm.EscalationStep+1 < len(policy.Steps) {
+		next := now.Add(time.Duration(policy.Steps[alarm.EscalationStep+1].DelayMinutes) * time.Minute)
+		update["$set"].(bson.M)["next_escalation_at"] = next
+	} else {
+		update["$unset"] = bson.M{"next_escalation_at": ""}
+	}
+
+	// Claim the step before notifying so another instance cannot run it twice
+	result, err := collection.UpdateOne(ctx, bson.M{
+		"_id":             alarm.ID,
+		"status":          models.AlarmStatusOpen,
+		"escalation_step": alarm.EscalationStep,
+	}, update)
+	if err != nil || result.ModifiedCount == 0 {
+		return err
+	}
+
+	alert := buildAlert(sensor, vibration, alarm.Level)
+	event := notifyUsers(ctx, users, alert, alarm.EscalationStep+1)
+	if len(event.Channels) > 0 {
+		return recordEscalation(ctx, alarm.ID, event)
+	}
+
+	// Nothing was delivered: give the step back to be tried again
+	event.Error = "no notification was delivered"
+	_, err = collection.UpdateOne(ctx, bson.M{
+		"_id":             alarm.ID,
+		"escalation_step": alarm.EscalationStep + 1,
+	}, bson.M{
+		"$set":  bson.M{"escalation_step": alarm.EscalationStep, "next_escalation_at": now.Add(escalationRetryDelay)},
+		"$push": bson.M{"escalations": event},
+	})
+	return err
+}
+
+// retryEscalation records an escalation step that could not notify anyone
+// and tries it again after escalationRetryDelay
+func retryEscalation(ctx context.Context, alarm models.Alarm, now time.Time, reason string) error {
+	event := models.EscalationEvent{
+		Step:       alarm.EscalationStep + 1,
+		UserIDs:    []primitive.ObjectID{},
+		Channels:   []string{},
+		NotifiedAt: now,
+		Error:      reason,
+	}
+	_, err := config.GetCollection("alarms").UpdateOne(ctx, bson.M{
+		"_id":             alarm.ID,
+		"status":          models.AlarmStatusOpen,
+		"escalation_step": alarm.EscalationStep,
+	}, bson.M{
+		"$set":  bson.M{"next_escalation_at": now.Add(escalationRetryDelay)},
+		"$push": bson.M{"escalations": event},
+	})
+	return err
+}
+
+// resolveEscalationTargets returns the users an escalation step should notify
+func resolveEscalationTargets(ctx context.Context, step models.EscalationStep, now time.Time) ([]models.User, error) {
+	userID := step.TargetID
+
+	if step.TargetType == models.EscalationTargetSchedule {
+		var schedule models.OnCallSchedule
+		err := config.GetCollection("oncall_schedules").FindOne(ctx, bson.M{"_id": step.TargetID}).Decode(&schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		onCall, ok := currentOnCall(schedule, now)
+		if !ok {
+			return nil, nil
+		}
+		userID = onCall
+	}
+
+	var user models.User
+	if err := config.GetCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, err
+	}
+	return []models.User{user}, nil
+}
+
+// GetAlarms retrieves alarms with optional filtering by status, serial number and organization
+func GetAlarms(c *gin.Context) {
+	alarms := []models.Alarm{}
+	collection := config.GetCollection("alarms")
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	if serialNumber := c.Query("serial_number"); serialNumber != "" {
+		filter["serial_number"] = serialNumber
+	}
+	if organization := c.Query("organization"); organization != "" {
+		filter["organization"] = organization
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "triggered_at", Value: -1}})
+	cursor, err := collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &alarms); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, alarms)
+}
+
+// GetAlarm retrieves an alarm by ID, including its escalation history
+func GetAlarm(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var alarm models.Alarm
+	collection := config.GetCollection("alarms")
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&alarm)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alarm not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, alarm)
+}
+
+// AcknowledgeAlarm marks an open alarm as acknowledged and stops its escalation
+func AcknowledgeAlarm(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var request struct {
+		UserID primitive.ObjectID `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	collection := config.GetCollection("alarms")
+	update := bson.M{
+		"$set": bson.M{
+			"status":          models.AlarmStatusAcknowledged,
+			"acknowledged_at": time.Now(),
+			"acknowledged_by": request.UserID,
+		},
+		"$unset": bson.M{"next_escalation_at": ""},
+	}
+
+	var alarm models.Alarm
+	err = collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": objectID, "status": models.AlarmStatusOpen},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&alarm)
+	if err == mongo.ErrNoDocuments {
+		alarmNotOpen(c, objectID)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	publishAlarm(alarm)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alarm acknowledged"})
+}
+
+// ResolveAlarm closes an alarm manually
+func ResolveAlarm(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	collection := config.GetCollection("alarms")
+	update := bson.M{
+		"$set":   bson.M{"status": models.AlarmStatusResolved, "resolved_at": time.Now()},
+		"$unset": bson.M{"next_escalation_at": ""},
+	}
+
+	var alarm models.Alarm
+	err = collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": objectID, "status": bson.M{"$ne": models.AlarmStatusResolved}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&alarm)
+	if err == mongo.ErrNoDocuments {
+		alarmNotOpen(c, objectID)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	publishAlarm(alarm)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alarm resolved"})
+}
+
+// alarmNotOpen responds 404 for unknown alarms and 409 for alarms in the wrong state
+func alarmNotOpen(c *gin.Context, objectID primitive.ObjectID) {
+	count, err := config.GetCollection("alarms").CountDocuments(context.Background(), bson.M{"_id": objectID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alarm not found"})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "Alarm is not in a state that allows this action"})
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/analysis"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAnalyticsPoints = 500
+	maxAnalyticsPoints     = 5000
+	defaultBandCount       = 4
+)
+
+// frequencyBand is a [Low, High) range in Hz
+type frequencyBand struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+type bandResult struct {
+	frequencyBand
+	RMS float64 `json:"rms"`
+}
+
+// axisAnalytics holds the indicators computed from one axis spectrum
+type axisAnalytics struct {
+	Bands   []bandResult       `json:"bands"`
+	Orders  map[string]float64 `json:"orders,omitempty"`
+	Bearing map[string]float64 `json:"bearing,omitempty"`
+}
+
+type analyticsPoint struct {
+	Time        time.Time                `json:"time"`
+	VibrationID primitive.ObjectID       `json:"vibration_id"`
+	Axes        map[string]axisAnalytics `json:"axes"`
+}
+
+// parseTimeRange reads start_date and end_date (RFC3339) into a measured_at filter
+func parseTimeRange(c *gin.Context) (bson.M, error) {
+	dateFilter := bson.M{}
+	if startDate := c.Query("start_date"); startDate != "" {
+		t, err := time.Parse(time.RFC3339, startDate)
+		if err != nil {
+			return nil, fmt.Errorf("start_date must be RFC3339")
+		}
+		dateFilter["$gte"] = t
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		t, err := time.Parse(time.RFC3339, endDate)
+		if err != nil {
+			return nil, fmt.Errorf("end_date must be RFC3339")
+		}
+		dateFilter["$lte"] = t
+	}
+	return dateFilter, nil
+}
+
+// parseBands reads bands such as "10-100,100-1000"; without it the range up to
+// fmax is split into equal bands
+func parseBands(value string, fmax float64) ([]frequencyBand, error) {
+	var bands []frequencyBand
+	if value == "" {
+		if fmax <= 0 {
+			return nil, nil
+		}
+		width := fmax / defaultBandCount
+		for i := 0; i < defaultBandCount; i++ {
+			bands = append(bands, frequencyBand{Low: float64(i) * width, High: float64(i+1) * width})
+		}
+		return bands, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid band %q, expected low-high", part)
+		}
+		low, err1 := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64)
+		high, err2 := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64)
+		if err1 != nil || err2 != nil || low < 0 || high <= low {
+			return nil, fmt.Errorf("invalid band %q", part)
+		}
+		bands = append(bands, frequencyBand{Low: low, High: high})
+	}
+	return bands, nil
+}
+
+// readingAxis returns the bin width and scaling for a stored reading, falling
+// back to the sensor's current configuration for readings without metadata
+func readingAxis(sensor models.Sensor, vibration models.VibrationData) (float64, string) {
+	if meta := vibration.Spectral; meta != nil && meta.BinWidth > 0 {
+		return meta.BinWidth, meta.Scaling
+	}
+	if sensor.FMax > 0 && len(vibration.FFTX) > 0 {
+		return sensor.FMax / float64(len(vibration.FFTX)), models.ScalingPeak
+	}
+	return 0, models.ScalingPeak
+}
+
+// GetSpectralAnalytics returns band energy, running speed order amplitudes and
+// bearing defect amplitudes for a sensor over time. It covers the latest limit
+// readings in the range and sets truncated when older ones were left out.
+func GetSpectralAnalytics(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sensor id"})
+		return
+	}
+
+	var sensor models.Sensor
+	err = config.GetCollection("sensors").FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&sensor)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sensor"})
+		return
+	}
+
+	bands, err := parseBands(c.Query("bands"), sensor.FMax)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rpm := sensor.RunningSpeedRPM
+	if value := c.Query("rpm"); value != "" {
+		rpm, err = strconv.ParseFloat(value, 64)
+		if err != nil || rpm <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rpm must be a positive number"})
+			return
+		}
+	}
+	shaftHz := rpm / 60
+
+	tolerance, err := strconv.Atoi(c.DefaultQuery("tolerance_bins", "2"))
+	if err != nil || tolerance < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance_bins must be a non-negative integer"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAnalyticsPoints)))
+	if err != nil || limit <= 0 || limit > maxAnalyticsPoints {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxAnalyticsPoints)})
+		return
+	}
+
+	axes := []string{"x", "y", "z"}
+	if axis := c.Query("axis"); axis != "" {
+		if axis != "x" && axis != "y" && axis != "z" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "axis must be x, y or z"})
+			return
+		}
+		axes = []string{axis}
+	}
+
+	dateFilter, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter := bson.M{"serial_number": sensor.SerialNumber}
+	if len(dateFilter) > 0 {
+		filter["measured_at"] = dateFilter
+	}
+
+	// The latest readings in the range, plus one to tell whether it was cut
+	opts := options.Find().
+		SetSort(bson.D{{Key: "measured_at", Value: -1}}).
+		SetLimit(int64(limit) + 1).
+		SetProjection(bson.M{"measured_at": 1, "fft_x": 1, "fft_y": 1, "fft_z": 1, "spectral": 1, "spectra_blob": 1})
+
+	cursor, err := config.GetCollection("vibrations").Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var readings []models.VibrationData
+	if err := cursor.All(context.Background(), &readings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	truncated := len(readings) > limit
+	if truncated {
+		readings = readings[:limit]
+	}
+	if err := hydrateSpectraAll(context.Background(), readings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var faults *analysis.BearingFrequencies
+	if sensor.Bearing != nil && shaftHz > 0 && sensor.Bearing.PitchDiameter > 0 && sensor.Bearing.BallDiameter > 0 {
+		f := analysis.DefectFrequencies(*sensor.Bearing, shaftHz)
+		faults = &f
+	}
+
+	// Oldest first so the series reads left to right
+	series := []analyticsPoint{}
+	for i := len(readings) - 1; i >= 0; i-- {
+		vib := readings[i]
+		binWidth, scaling := readingAxis(sensor, vib)
+		spectra := map[string][]float64{"x": vib.FFTX, "y": vib.FFTY, "z": vib.FFTZ}
+
+		point := analyticsPoint{Time: vib.MeasuredAt, VibrationID: vib.ID, Axes: map[string]axisAnalytics{}}
+		for _, axis := range axes {
+			amplitudes := spectra[axis]
+			result := axisAnalytics{Bands: []bandResult{}}
+
+			for _, band := range bands {
+				result.Bands = append(result.Bands, bandResult{
+					frequencyBand: band,
+					RMS:           analysis.BandRMS(amplitudes, binWidth, band.Low, band.High, scaling),
+				})
+			}
+
+			if shaftHz > 0 {
+				result.Orders = map[string]float64{
+					"1x": analysis.AmplitudeAt(amplitudes, binWidth, shaftHz, tolerance),
+					"2x": analysis.AmplitudeAt(amplitudes, binWidth, 2*shaftHz, tolerance),
+					"3x": analysis.AmplitudeAt(amplitudes, binWidth, 3*shaftHz, tolerance),
+				}
+			}
+
+			if faults != nil {
+				result.Bearing = map[string]float64{
+					"bpfo": analysis.AmplitudeAt(amplitudes, binWidth, faults.BPFO, tolerance),
+					"bpfi": analysis.AmplitudeAt(amplitudes, binWidth, faults.BPFI, tolerance),
+					"bsf":  analysis.AmplitudeAt(amplitudes, binWidth, faults.BSF, tolerance),
+					"ftf":  analysis.AmplitudeAt(amplitudes, binWidth, faults.FTF, tolerance),
+				}
+			}
+
+			point.Axes[axis] = result
+		}
+		series = append(series, point)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"serial_number":     sensor.SerialNumber,
+		"running_speed_hz":  shaftHz,
+		"fault_frequencies": faults,
+		"bands":             bands,
+		"series":            series,
+		"truncated":         truncated,
+	})
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/codec"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Readings written per unordered insert
+	backfillChunkSize = 500
+
+	// Longest NDJSON line accepted; longer lines are reported and skipped
+	maxBackfillLineBytes = 8 << 20
+)
+
+// backfillResult reports what happened to one line of a backfill stream,
+// in the terms of a batch item
+type backfillResult struct {
+	Line      int    `json:"line"`
+	Status    string `json:"status"`
+	ID        string `json:"id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Code      int    `json:"code"`
+	Message   string `json:"message,omitempty"`
+
+	RetryAfter int `json:"retry_after,omitempty"` // Seconds, for rate limited lines
+}
+
+// backfillRun collects the results of one backfill stream
+type backfillRun struct {
+	sensorFor  func(serialNumber string) (models.Sensor, error)
+	receivedAt time.Time
+	results    []backfillResult
+	pending    []pendingReading
+	counts     map[string]int
+
+	// Set once a rate limit turned readings away; every later line is
+	// turned away with it
+	limited *RateLimitError
+}
+
+func (r *backfillRun) fail(index int, err error) {
+	r.results[index].Status = batchFailed
+	r.results[index].Code = ingestErrorStatus(err)
+	r.results[index].Message = err.Error()
+	if limitErr, ok := err.(*RateLimitError); ok {
+		r.results[index].RetryAfter = limitErr.RetryAfterSeconds()
+	}
+	r.counts[batchFailed]++
+}
+
+// add validates one line and queues it for insertion. A line larger than
+// its sensor's payload quota fails as a single upload of it would.
+func (r *backfillRun) add(ctx context.Context, line int, data []byte) {
+	index := len(r.results)
+	r.results = append(r.results, backfillResult{Line: line})
+	if r.limited != nil {
+		r.fail(index, r.limited)
+		return
+	}
+
+	var vibration models.VibrationData
+	if err := json.Unmarshal(data, &vibration); err != nil {
+		r.fail(index, &IngestError{http.StatusBadRequest, "invalid JSON: " + err.Error()})
+		return
+	}
+	r.results[index].MessageID = vibration.MessageID
+
+	sensor, err := r.sensorFor(vibration.SerialNumber)
+	if err == nil && sensor.Quota != nil && sensor.Quota.MaxPayloadBytes > 0 && int64(len(data)) > sensor.Quota.MaxPayloadBytes {
+		err = &IngestError{http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Payload exceeds the sensor's quota of %d bytes", sensor.Quota.MaxPayloadBytes)}
+	}
+	if err == nil {
+		err = prepareVibration(ctx, sensor, &vibration, r.receivedAt)
+	}
+	if err != nil {
+		r.fail(index, err)
+		return
+	}
+
+	r.pending = append(r.pending, pendingReading{index: index, sensor: sensor, vibration: vibration})
+}
+
+// charge takes the pending readings from their sensors' rate limits and
+// returns those that were let through. Once a bucket turns a sensor's
+// readings away, they and the readings of sensors not yet charged fail.
+func (r *backfillRun) charge(ctx context.Context) []pendingReading {
+	var order []primitive.ObjectID
+	counts := map[primitive.ObjectID]int{}
+	sensors := map[primitive.ObjectID]models.Sensor{}
+	for _, item := range r.pending {
+		if counts[item.sensor.ID] == 0 {
+			order = append(order, item.sensor.ID)
+			sensors[item.sensor.ID] = item.sensor
+		}
+		counts[item.sensor.ID]++
+	}
+
+	allowed := map[primitive.ObjectID]bool{}
+	for _, id := range order {
+		err := limitIngest(ctx, sensors[id], counts[id])
+		if limitErr, ok := err.(*RateLimitError); ok {
+			r.limited = limitErr
+			break
+		}
+		allowed[id] = true
+	}
+
+	charged := r.pending[:0]
+	for _, item := range r.pending {
+		if allowed[item.sensor.ID] {
+			charged = append(charged, item)
+			continue
+		}
+		r.fail(item.index, r.limited)
+	}
+	return charged
+}
+
+// flush writes the pending readings that their rate limits let through
+func (r *backfillRun) flush(ctx context.Context) {
+	if len(r.pending) == 0 {
+		return
+	}
+	pending := r.charge(ctx)
+	if len(pending) == 0 {
+		r.pending = r.pending[:0]
+		return
+	}
+
+	insertReadings(ctx, pending, func(item *pendingReading, status string, err error) {
+		if err != nil {
+			r.fail(item.index, err)
+			return
+		}
+		result := &r.results[item.index]
+		result.Status = status
+		result.ID = item.vibration.ID.Hex()
+		result.Code = http.StatusCreated
+		if status == batchDuplicate {
+			result.Code = http.StatusOK
+		}
+		r.counts[status]++
+
+		// Buffered readings are history: they may open or raise an alarm but
+		// never resolve one, and they are not pushed to live streams
+		if status == batchCreated && evaluateWarningLevel(item.sensor, item.vibration) > 1 {
+			evaluateAlarm(item.sensor, item.vibration)
+		}
+	})
+
+	r.pending = r.pending[:0]
+}
+
+// readBackfillLine reads one line without its newline. Lines longer than
+// maxBackfillLineBytes are consumed and reported as too long.
+func readBackfillLine(reader *bufio.Reader) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > maxBackfillLineBytes+1 {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return bytes.TrimSpace(line), tooLong, err
+	}
+}
+
+// runBackfill reads an NDJSON stream of readings, one per line, and stores
+// them in chunks. The response reports every non-empty line by its 1-based
+// number. If the stream breaks off, lines after the last reported one were
+// not read and should be sent again.
+// Every chunk counts against its sensors' ingest rate limits. Once a limit
+// is reached, the remaining lines fail with 429 and retry_after, and should
+// be sent again after that wait.
+func runBackfill(c *gin.Context, sensorFor func(serialNumber string) (models.Sensor, error)) {
+	body, err := codec.Decompress(c.Request.Body, c.GetHeader("Content-Encoding"), config.GetConfig().MaxBackfillBodyBytes)
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+	run := &backfillRun{
+		sensorFor:  sensorFor,
+		receivedAt: time.Now(),
+		counts:     map[string]int{},
+	}
+
+	reader := bufio.NewReaderSize(body, 64<<10)
+	var readErr error
+	for line := 1; ; line++ {
+		data, tooLong, err := readBackfillLine(reader)
+		switch {
+		case tooLong:
+			run.results = append(run.results, backfillResult{Line: line})
+			run.fail(len(run.results)-1, &IngestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("line exceeds %d bytes", maxBackfillLineBytes)})
+		case len(data) > 0 && (err == nil || err == io.EOF):
+			run.add(ctx, line, data)
+		}
+		if len(run.pending) >= backfillChunkSize {
+			run.flush(ctx)
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = bindError(err)
+			}
+			break
+		}
+	}
+	run.flush(ctx)
+
+	response := gin.H{
+		"lines":      len(run.results),
+		"created":    run.counts[batchCreated],
+		"duplicates": run.counts[batchDuplicate],
+		"failed":     run.counts[batchFailed],
+		"results":    run.results,
+	}
+	if run.limited != nil {
+		response["retry_after"] = run.limited.RetryAfterSeconds()
+		c.Header("Retry-After", strconv.Itoa(run.limited.RetryAfterSeconds()))
+	}
+	if readErr != nil {
+		response["error"] = readErr.Error()
+		c.JSON(ingestErrorStatus(readErr), response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// BackfillVibrations accepts buffered readings of any of the caller's
+// sensors as NDJSON. Each sensor is looked up once per request.
+func BackfillVibrations(c *gin.Context) {
+	userID := c.MustGet("user_id").(primitive.ObjectID)
+
+	sensors := newSensorLookup(func(sensor models.Sensor) error {
+		if sensor.UserID != userID {
+			return &IngestError{http.StatusForbidden, "Sensor " + sensor.SerialNumber + " belongs to another user"}
+		}
+		return nil
+	})
+	runBackfill(c, sensors.find)
+}
+
+// BackfillVibrationsWithAPIKey accepts a sensor's buffered readings as NDJSON,
+// authenticating the sensor by API key
+func BackfillVibrationsWithAPIKey(c *gin.Context) {
+	sensor, err := authenticateAPIKey(context.Background(), c.Param("apikey"))
+	if err != nil {
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	runBackfill(c, func(string) (models.Sensor, error) {
+		return sensor, nil
+	})
+}
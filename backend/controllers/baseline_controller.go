@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/analysis"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	minBaselineSamples = 10
+	maxBaselineSamples = 10000
+
+	// Standard deviations above the envelope mean at which a bin counts as exceeded
+	envelopeSigma = 3
+)
+
+// baselineRequest selects the healthy period a baseline is learned from
+type baselineRequest struct {
+	Start time.Time `json:"start" binding:"required"`
+	End   time.Time `json:"end" binding:"required"`
+}
+
+// readingMetrics returns the scalar metrics of a reading by field name
+func readingMetrics(vibration models.VibrationData) map[string]float64 {
+	return map[string]float64{
+		"rms_x":  vibration.RMSX,
+		"rms_y":  vibration.RMSY,
+		"rms_z":  vibration.RMSZ,
+		"peak_x": vibration.PeakX,
+		"peak_y": vibration.PeakY,
+		"peak_z": vibration.PeakZ,
+	}
+}
+
+func readingSpectra(vibration models.VibrationData) map[string][]float64 {
+	return map[string][]float64{"x": vibration.FFTX, "y": vibration.FFTY, "z": vibration.FFTZ}
+}
+
+// sameFrequencyAxis reports whether a reading's spectra lie on the baseline's bins
+func sameFrequencyAxis(baseline models.Baseline, vibration models.VibrationData) bool {
+	if baseline.Spectral == nil || vibration.Spectral == nil {
+		return baseline.Spectral == nil && vibration.Spectral == nil
+	}
+	return baseline.Spectral.Lines == vibration.Spectral.Lines &&
+		baseline.Spectral.BinWidth == vibration.Spectral.BinWidth
+}
+
+// learnBaseline computes metric statistics and the spectral envelope from a
+// sensor's unsuppressed readings in [start, end]. Readings whose frequency axis
+// differs from the first one are left out of the envelope.
+func learnBaseline(ctx context.Context, sensor models.Sensor, start, end time.Time) (models.Baseline, error) {
+	baseline := models.Baseline{
+		SensorID:     sensor.ID,
+		SerialNumber: sensor.SerialNumber,
+		Start:        start,
+		End:          end,
+		Metrics:      map[string]models.MetricBaseline{},
+		Envelope:     map[string]models.SpectralEnvelope{},
+	}
+
+	filter := bson.M{
+		"serial_number": sensor.SerialNumber,
+		"measured_at":   bson.M{"$gte": start, "$lte": end},
+		"suppressed":    bson.M{"$ne": true},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "measured_at", Value: 1}}).
+		SetLimit(maxBaselineSamples).
+		SetProjection(bson.M{"velocity_x": 0, "velocity_y": 0, "velocity_z": 0, "waveform": 0})
+
+	cursor, err := config.GetCollection("vibrations").Find(ctx, filter, opts)
+	if err != nil {
+		return baseline, err
+	}
+	defer cursor.Close(ctx)
+
+	metrics := map[string]*analysis.RunningStats{}
+	for _, metric := range trendMetrics {
+		metrics[metric] = &analysis.RunningStats{}
+	}
+	bins := map[string][]analysis.RunningStats{}
+
+	for cursor.Next(ctx) {
+		var vib models.VibrationData
+		if err := cursor.Decode(&vib); err != nil {
+			return baseline, err
+		}
+		if err := hydrateSpectra(ctx, &vib); err != nil {
+			return baseline, err
+		}
+
+		for metric, value := range readingMetrics(vib) {
+			metrics[metric].Add(value)
+		}
+
+		if baseline.Samples == 0 {
+			baseline.Spectral = vib.Spectral
+			for axis, amplitudes := range readingSpectra(vib) {
+				bins[axis] = make([]analysis.RunningStats, len(amplitudes))
+			}
+		}
+		baseline.Samples++
+
+		if !sameFrequencyAxis(baseline, vib) {
+			continue
+		}
+		for axis, amplitudes := range readingSpectra(vib) {
+			if len(amplitudes) != len(bins[axis]) {
+				continue
+			}
+			for k, amplitude := range amplitudes {
+				bins[axis][k].Add(amplitude)
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return baseline, err
+	}
+
+	if baseline.Samples < minBaselineSamples {
+		return baseline, &IngestError{http.StatusUnprocessableEntity, fmt.Sprintf("baseline needs at least %d readings in the period, found %d", minBaselineSamples, baseline.Samples)}
+	}
+
+	for metric, stats := range metrics {
+		baseline.Metrics[metric] = models.MetricBaseline{Mean: stats.Mean(), StdDev: stats.StdDev()}
+	}
+	for axis, stats := range bins {
+		envelope := models.SpectralEnvelope{Mean: make([]float64, len(stats)), StdDev: make([]float64, len(stats))}
+		for k := range stats {
+			envelope.Mean[k] = stats[k].Mean()
+			envelope.StdDev[k] = stats[k].StdDev()
+		}
+		baseline.Envelope[axis] = envelope
+	}
+
+	return baseline, nil
+}
+
+// scoreAnomaly rates a reading against its sensor's baseline. Readings of
+// sensors without a baseline are left unscored.
+func scoreAnomaly(sensor models.Sensor, vibration *models.VibrationData) error {
+	var baseline models.Baseline
+	err := config.GetCollection("baselines").FindOne(context.Background(), bson.M{"sensor_id": sensor.ID}).Decode(&baseline)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	score := &models.AnomalyScore{BaselineID: baseline.ID}
+	for metric, value := range readingMetrics(*vibration) {
+		stats, ok := baseline.Metrics[metric]
+		if !ok {
+			continue
+		}
+		if z := math.Abs(analysis.ZScore(value, stats.Mean, stats.StdDev)); z > score.Score {
+			score.Score = z
+			score.Metric = metric
+		}
+	}
+
+	if sameFrequencyAxis(baseline, *vibration) {
+		for axis, amplitudes := range readingSpectra(*vibration) {
+			envelope := baseline.Envelope[axis]
+			if len(envelope.Mean) != len(amplitudes) {
+				continue
+			}
+			for k, amplitude := range amplitudes {
+				if analysis.ZScore(amplitude, envelope.Mean[k], envelope.StdDev[k]) > envelopeSigma {
+					score.EnvelopeExceedances++
+				}
+			}
+		}
+	}
+
+	vibration.Anomaly = score
+	return nil
+}
+
+// anomalyLevel maps an anomaly score to a warning level: Warning from the
+// sensor's threshold and Critical from twice the threshold. Anomalies never
+// raise Emergency on their own; that stays tied to AlarmThs.
+func anomalyLevel(sensor models.Sensor, vibration models.VibrationData) int {
+	if vibration.Anomaly == nil {
+		return 1
+	}
+
+	threshold := sensor.AnomalyThreshold
+	if threshold <= 0 {
+		threshold = config.GetConfig().AnomalyThreshold
+	}
+	if threshold <= 0 {
+		return 1
+	}
+
+	switch {
+	case vibration.Anomaly.Score >= 2*threshold:
+		return 3
+	case vibration.Anomaly.Score >= threshold:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// findSensorByParam loads the sensor named by the :id route parameter,
+// writing the error response when it cannot
+func findSensorByParam(c *gin.Context) (models.Sensor, bool) {
+	var sensor models.Sensor
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sensor id"})
+		return sensor, false
+	}
+
+	err = config.GetCollection("sensors").FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&sensor)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
+			return sensor, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sensor"})
+		return sensor, false
+	}
+	return sensor, true
+}
+
+// SetBaseline learns a sensor's baseline from a healthy period, replacing any
+// existing baseline
+func SetBaseline(c *gin.Context) {
+	sensor, ok := findSensorByParam(c)
+	if !ok {
+		return
+	}
+
+	var request baselineRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !request.End.After(request.Start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must be after start"})
+		return
+	}
+
+	baseline, err := learnBaseline(context.Background(), sensor, request.Start, request.End)
+	if err != nil {
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	baseline.CreatedAt = time.Now()
+
+	collection := config.GetCollection("baselines")
+	var stored models.Baseline
+	err = collection.FindOneAndReplace(context.Background(), bson.M{"sensor_id": sensor.ID}, baseline,
+		options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)).Decode(&stored)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store baseline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stored)
+}
+
+// GetBaseline returns a sensor's baseline
+func GetBaseline(c *gin.Context) {
+	sensor, ok := findSensorByParam(c)
+	if !ok {
+		return
+	}
+
+	var baseline models.Baseline
+	err := config.GetCollection("baselines").FindOne(context.Background(), bson.M{"sensor_id": sensor.ID}).Decode(&baseline)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "baseline not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get baseline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, baseline)
+}
+
+// DeleteBaseline resets a sensor's baseline; later readings are not scored
+func DeleteBaseline(c *gin.Context) {
+	sensor, ok := findSensorByParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := config.GetCollection("baselines").DeleteOne(context.Background(), bson.M{"sensor_id": sensor.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete baseline"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "baseline not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "baseline deleted"})
+}
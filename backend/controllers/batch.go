@@ -0,0 +1,389 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Status of an item in a batch or backfill response
+const (
+	batchCreated   = "created"
+	batchDuplicate = "duplicate" // Already stored under the same message ID
+	batchFailed    = "failed"
+	batchAborted   = "aborted" // Valid, but not stored because an atomic batch failed
+)
+
+// MongoDB error code for a unique index violation
+const duplicateKeyCode = 11000
+
+// itemError is a failure of one item of a batch of sensors or users, with
+// the HTTP status the item would have had as a single request
+type itemError struct {
+	Status  int
+	Message string
+}
+
+func (e *itemError) Error() string {
+	return e.Message
+}
+
+// itemErrorStatus returns the HTTP status for a failed batch item
+func itemErrorStatus(err error) int {
+	if err, ok := err.(*itemError); ok {
+		return err.Status
+	}
+	return ingestErrorStatus(err)
+}
+
+// insertError maps a failed insert to an item error: a unique index
+// violation is a conflict with an existing document
+func insertError(err error, conflict, message string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return &itemError{http.StatusConflict, conflict}
+	}
+	return &itemError{http.StatusInternalServerError, message}
+}
+
+// batchResult reports the outcome of one item of a batch request. Code is
+// the HTTP status the item would have had as a single request.
+type batchResult struct {
+	Index      int         `json:"index"`
+	Status     string      `json:"status"`
+	ID         string      `json:"id,omitempty"`
+	Code       int         `json:"code"`
+	Message    string      `json:"message,omitempty"`
+	RetryAfter int         `json:"retry_after,omitempty"` // Seconds, for rate limited items
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// batchReport collects the per-item results of a batch request. With
+// ?atomic=true every item is stored in one transaction or none is.
+type batchReport struct {
+	atomic  bool
+	results []batchResult
+}
+
+func newBatchReport(c *gin.Context, size int) (*batchReport, error) {
+	report := &batchReport{results: make([]batchResult, size)}
+	if value := c.Query("atomic"); value != "" {
+		atomic, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("atomic must be true or false")
+		}
+		report.atomic = atomic
+	}
+	for i := range report.results {
+		report.results[i].Index = i
+	}
+	return report, nil
+}
+
+func (r *batchReport) fail(index int, err error) {
+	r.results[index] = batchResult{Index: index, Status: batchFailed, Code: itemErrorStatus(err), Message: err.Error()}
+	if limitErr, ok := err.(*RateLimitError); ok {
+		r.results[index].RetryAfter = limitErr.RetryAfterSeconds()
+	}
+}
+
+func (r *batchReport) succeed(index int, status string, id primitive.ObjectID, data interface{}) {
+	code := http.StatusCreated
+	if status == batchDuplicate {
+		code = http.StatusOK
+	}
+	r.results[index] = batchResult{Index: index, Status: status, ID: id.Hex(), Code: code, Data: data}
+}
+
+// failInsert reports the items of an atomic insert that failed. When a
+// unique index rejected one of them, that item fails with 409 Conflict and
+// the others are aborted.
+func (r *batchReport) failInsert(indexes []int, err error, conflict, message string) {
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code == duplicateKeyCode && writeErr.Index < len(indexes) {
+				r.fail(indexes[writeErr.Index], &itemError{http.StatusConflict, conflict})
+				r.abort()
+				return
+			}
+		}
+	}
+	for _, i := range indexes {
+		r.fail(i, &itemError{http.StatusInternalServerError, message + ": " + err.Error()})
+	}
+}
+
+// failed reports whether any item failed
+func (r *batchReport) failed() bool {
+	for _, result := range r.results {
+		if result.Status == batchFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// abort marks every item that was neither stored earlier nor failed as not
+// stored
+func (r *batchReport) abort() {
+	for i, result := range r.results {
+		if result.Status != batchFailed && result.Status != batchDuplicate {
+			r.results[i] = batchResult{Index: i, Status: batchAborted, Code: http.StatusFailedDependency,
+				Message: "not stored because another item of the atomic batch failed"}
+		}
+	}
+}
+
+// respond writes the report: 201 when every item was stored, 207 when some
+// were, and otherwise the status shared by the failures, or 400
+func (r *batchReport) respond(c *gin.Context) {
+	counts := map[string]int{}
+	failedCode, retryAfter := 0, 0
+	for _, result := range r.results {
+		counts[result.Status]++
+		if result.RetryAfter > retryAfter {
+			retryAfter = result.RetryAfter
+		}
+		if result.Status == batchFailed {
+			if failedCode == 0 {
+				failedCode = result.Code
+			} else if failedCode != result.Code {
+				failedCode = http.StatusBadRequest
+			}
+		}
+	}
+
+	stored := counts[batchCreated] + counts[batchDuplicate]
+	status := http.StatusCreated
+	switch {
+	case len(r.results) == 0 || stored == len(r.results):
+	case stored > 0:
+		status = http.StatusMultiStatus
+	case failedCode != 0:
+		status = failedCode
+	default:
+		status = http.StatusBadRequest
+	}
+
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+	}
+	c.JSON(status, gin.H{
+		"atomic":     r.atomic,
+		"total":      len(r.results),
+		"created":    counts[batchCreated],
+		"duplicates": counts[batchDuplicate],
+		"failed":     counts[batchFailed],
+		"aborted":    counts[batchAborted],
+		"results":    r.results,
+	})
+}
+
+// withTransaction runs fn in a MongoDB transaction, which needs a replica set
+func withTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := config.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// sensorLookup finds sensors by serial number for a multi-sensor request,
+// querying each serial number once. check, when set, vets each sensor found.
+type sensorLookup struct {
+	check   func(sensor models.Sensor) error
+	sensors map[string]models.Sensor
+	errors  map[string]error
+}
+
+func newSensorLookup(check func(sensor models.Sensor) error) *sensorLookup {
+	return &sensorLookup{check: check, sensors: map[string]models.Sensor{}, errors: map[string]error{}}
+}
+
+func (l *sensorLookup) find(serialNumber string) (models.Sensor, error) {
+	if serialNumber == "" {
+		return models.Sensor{}, &IngestError{http.StatusBadRequest, "Serial number is required"}
+	}
+	if sensor, ok := l.sensors[serialNumber]; ok {
+		return sensor, nil
+	}
+	if err, ok := l.errors[serialNumber]; ok {
+		return models.Sensor{}, err
+	}
+
+	sensor, err := sensorBySerialNumber(context.Background(), serialNumber)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		err = &IngestError{http.StatusBadRequest, "Invalid serial number: " + serialNumber}
+	case err != nil:
+		err = &IngestError{http.StatusInternalServerError, "Failed to get sensor"}
+	case l.check != nil:
+		err = l.check(sensor)
+	}
+	if err != nil {
+		l.errors[serialNumber] = err
+		return models.Sensor{}, err
+	}
+	l.sensors[serialNumber] = sensor
+	return sensor, nil
+}
+
+// pendingReading is a validated reading waiting for a bulk insert
+type pendingReading struct {
+	index     int // Position in the request
+	sensor    models.Sensor
+	vibration models.VibrationData
+	claimed   bool // Message ID claimed when the reading was queued
+}
+
+// insertReadings claims message IDs and writes readings with one unordered
+// insert, so that a failing reading does not stop the others. Readings
+// claimed earlier are checked to still hold their claim. done is called once
+// per reading with its status and, for failures, the error.
+func insertReadings(ctx context.Context, pending []pendingReading, done func(item *pendingReading, status string, err error)) {
+	lost, lostErr := lostIngestClaims(ctx, pending)
+
+	var inserted []*pendingReading
+	var documents []interface{}
+	var stored []models.VibrationData
+	for i := range pending {
+		item := &pending[i]
+		if item.claimed {
+			switch {
+			case lostErr != nil:
+				releaseIngestKey(ctx, item.vibration)
+				done(item, batchFailed, lostErr)
+				continue
+			case lost[i]:
+				done(item, batchDuplicate, nil)
+				continue
+			}
+		} else {
+			replayed, err := claimIngestKey(ctx, &item.vibration)
+			if err != nil {
+				done(item, batchFailed, err)
+				continue
+			}
+			if replayed {
+				done(item, batchDuplicate, nil)
+				continue
+			}
+		}
+
+		annotateVibration(item.sensor, &item.vibration)
+		if item.vibration.ID.IsZero() {
+			item.vibration.ID = primitive.NewObjectID()
+		}
+		document := storedVibration(ctx, &item.vibration)
+		inserted = append(inserted, item)
+		documents = append(documents, document)
+		stored = append(stored, document)
+	}
+
+	failed := map[int]error{}
+	if len(documents) > 0 {
+		_, err := config.GetCollection("vibrations").InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+		var bulkErr mongo.BulkWriteException
+		switch {
+		case err == nil:
+		case errors.As(err, &bulkErr):
+			for _, writeErr := range bulkErr.WriteErrors {
+				failed[writeErr.Index] = &IngestError{http.StatusInternalServerError, writeErr.Message}
+			}
+		default:
+			for k := range documents {
+				failed[k] = &IngestError{http.StatusInternalServerError, "Failed to store vibration data"}
+			}
+		}
+	}
+
+	for k, item := range inserted {
+		if err, ok := failed[k]; ok {
+			releaseIngestKey(ctx, item.vibration)
+			deleteSpectraBlob(ctx, stored[k].SpectraBlob)
+			done(item, batchFailed, err)
+			continue
+		}
+		done(item, batchCreated, nil)
+	}
+}
+
+// insertReadingsAtomic writes readings and their message ID claims in one
+// transaction. Readings already stored under their message ID are reported
+// as duplicates and left out. On error the readings that caused it are
+// reported failed and the error is returned; nothing was stored.
+func insertReadingsAtomic(ctx context.Context, pending []pendingReading, done func(item *pendingReading, status string, err error)) error {
+	var inserted []*pendingReading
+	for i := range pending {
+		item := &pending[i]
+		replayed, err := checkIngestKey(ctx, &item.vibration)
+		if err != nil {
+			done(item, batchFailed, err)
+			return err
+		}
+		if replayed {
+			done(item, batchDuplicate, nil)
+			continue
+		}
+		inserted = append(inserted, item)
+	}
+	if len(inserted) == 0 {
+		return nil
+	}
+
+	var documents, claims []interface{}
+	var stored []models.VibrationData
+	for _, item := range inserted {
+		annotateVibration(item.sensor, &item.vibration)
+		item.vibration.ID = primitive.NewObjectID()
+		if item.vibration.MessageID != "" {
+			claims = append(claims, newIngestKey(item.vibration))
+		}
+		document := storedVibration(ctx, &item.vibration)
+		documents = append(documents, document)
+		stored = append(stored, document)
+	}
+
+	err := withTransaction(ctx, func(sc mongo.SessionContext) error {
+		if len(claims) > 0 {
+			if _, err := config.GetCollection("ingest_keys").InsertMany(sc, claims); err != nil {
+				return err
+			}
+		}
+		_, err := config.GetCollection("vibrations").InsertMany(sc, documents)
+		return err
+	})
+	if err != nil {
+		for _, document := range stored {
+			deleteSpectraBlob(ctx, document.SpectraBlob)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			err = errIngestInProgress
+		} else {
+			err = &IngestError{http.StatusInternalServerError, "Failed to store vibration data: " + err.Error()}
+		}
+		for _, item := range inserted {
+			done(item, batchFailed, err)
+		}
+		return err
+	}
+
+	for _, item := range inserted {
+		done(item, batchCreated, nil)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/blobstore"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/codec"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// spectraArrays returns the offloadable arrays of a reading in blob order:
+// fft x/y/z, velocity x/y/z, waveform x/y/z
+func spectraArrays(vibration *models.VibrationData) []*[]float64 {
+	arrays := []*[]float64{
+		&vibration.FFTX, &vibration.FFTY, &vibration.FFTZ,
+		&vibration.VelocityX, &vibration.VelocityY, &vibration.VelocityZ,
+	}
+	if vibration.Waveform != nil {
+		arrays = append(arrays, &vibration.Waveform.X, &vibration.Waveform.Y, &vibration.Waveform.Z)
+	}
+	return arrays
+}
+
+// storedVibration returns the document to insert for a reading. When a blob
+// store is configured and the arrays exceed the size threshold, they are
+// written to the store and left out of the document. The reading is given
+// an ID up front so the blob key can name it. Offload failures fall back to
+// inline storage.
+func storedVibration(ctx context.Context, vibration *models.VibrationData) models.VibrationData {
+	store := blobstore.Default()
+	if store == nil {
+		return *vibration
+	}
+
+	arrays := spectraArrays(vibration)
+	values := make([][]float64, len(arrays))
+	size := 0
+	for i, array := range arrays {
+		values[i] = *array
+		size += len(*array) * 8
+	}
+	if size <= config.GetConfig().BlobThresholdBytes {
+		return *vibration
+	}
+
+	if vibration.ID.IsZero() {
+		vibration.ID = primitive.NewObjectID()
+	}
+
+	data, err := codec.EncodeSpectra(values)
+	if err != nil {
+		log.Printf("Blob store error - Encoding spectra of %s: %v", vibration.ID.Hex(), err)
+		return *vibration
+	}
+	sum := sha256.Sum256(data)
+	ref := &models.BlobRef{
+		Key:      fmt.Sprintf("vibrations/%s/%s", vibration.SerialNumber, vibration.ID.Hex()),
+		Encoding: codec.SpectraEncoding,
+		Size:     len(data),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+	if err := store.Put(ctx, ref.Key, data); err != nil {
+		log.Printf("Blob store error - Storing spectra of %s: %v", vibration.ID.Hex(), err)
+		return *vibration
+	}
+
+	stored := *vibration
+	stored.SpectraBlob = ref
+	if vibration.Waveform != nil {
+		waveform := *vibration.Waveform
+		stored.Waveform = &waveform
+	}
+	for _, array := range spectraArrays(&stored) {
+		*array = nil
+	}
+	return stored
+}
+
+// hydrateSpectra loads offloaded arrays back into a reading and verifies
+// their checksum. Arrays already present, such as spectra replaced by an
+// update, are kept. Readings stored inline are left unchanged.
+func hydrateSpectra(ctx context.Context, vibration *models.VibrationData) error {
+	ref := vibration.SpectraBlob
+	if ref == nil {
+		return nil
+	}
+
+	store := blobstore.Default()
+	if store == nil {
+		return fmt.Errorf("spectra of %s are in object storage but no blob store is configured", vibration.ID.Hex())
+	}
+
+	data, err := store.Get(ctx, ref.Key)
+	if err != nil {
+		return fmt.Errorf("failed to load spectra of %s: %v", vibration.ID.Hex(), err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != ref.Checksum {
+		return fmt.Errorf("spectra of %s failed checksum verification", vibration.ID.Hex())
+	}
+
+	values, err := codec.DecodeSpectra(data)
+	if err != nil {
+		return err
+	}
+	for i, array := range spectraArrays(vibration) {
+		if i < len(values) && len(*array) == 0 && len(values[i]) > 0 {
+			*array = values[i]
+		}
+	}
+	return nil
+}
+
+// Blobs fetched at once by hydrateSpectraAll
+const hydrateConcurrency = 8
+
+// hydrateSpectraAll hydrates a list of readings, fetching their blobs
+// concurrently. It returns the first error.
+func hydrateSpectraAll(ctx context.Context, vibrations []models.VibrationData) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(vibrations))
+	slots := make(chan struct{}, hydrateConcurrency)
+	for i := range vibrations {
+		if vibrations[i].SpectraBlob == nil {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			errs[i] = hydrateSpectra(ctx, &vibrations[i])
+			<-slots
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSpectraBlob removes the offloaded arrays of a deleted reading
+func deleteSpectraBlob(ctx context.Context, ref *models.BlobRef) {
+	store := blobstore.Default()
+	if ref == nil || store == nil {
+		return
+	}
+	if err := store.Delete(ctx, ref.Key); err != nil {
+		log.Printf("Blob store error - Deleting %s: %v", ref.Key, err)
+	}
+}
+
+// deleteSpectraBlobs removes the offloaded arrays of the readings matching
+// filter ahead of a bulk delete
+func deleteSpectraBlobs(ctx context.Context, filter bson.M) error {
+	if blobstore.Default() == nil {
+		return nil
+	}
+
+	blobFilter := bson.M{"spectra_blob": bson.M{"$exists": true}}
+	for key, value := range filter {
+		blobFilter[key] = value
+	}
+	cursor, err := config.GetCollection("vibrations").Find(ctx, blobFilter,
+		options.Find().SetProjection(bson.M{"spectra_blob": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var vib models.VibrationData
+		if err := cursor.Decode(&vib); err != nil {
+			return err
+		}
+		deleteSpectraBlob(ctx, vib.SpectraBlob)
+	}
+	return cursor.Err()
+}
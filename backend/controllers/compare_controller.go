@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/analysis"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultCompareTop = 10
+	maxCompareTop     = 100
+)
+
+// comparedSpectra holds one side of a comparison: peak amplitudes per axis
+// on the side's own frequency axis, in Units
+type comparedSpectra struct {
+	BinWidth float64
+	Units    string
+	Axes     map[string][]float64
+}
+
+// normalizeSpectra converts amplitudes to peak scaling and m/s² to g so that
+// spectra recorded with different conventions can be compared
+func normalizeSpectra(spectra map[string][]float64, binWidth float64, meta *models.SpectralMetadata) comparedSpectra {
+	units, scaling := models.UnitsG, models.ScalingPeak
+	if meta != nil {
+		units, scaling = meta.Units, meta.Scaling
+	}
+
+	out := comparedSpectra{BinWidth: binWidth, Units: units, Axes: map[string][]float64{}}
+	for axis, amplitudes := range spectra {
+		peak := analysis.ToPeak(amplitudes, scaling)
+		if units == models.UnitsMPerSec2 {
+			for k := range peak {
+				peak[k] /= analysis.StandardGravity
+			}
+		}
+		out.Axes[axis] = peak
+	}
+	if units == models.UnitsMPerSec2 {
+		out.Units = models.UnitsG
+	}
+	return out
+}
+
+// loadComparedReading loads a reading and its spectra for comparison
+func loadComparedReading(ctx context.Context, id primitive.ObjectID) (models.VibrationData, comparedSpectra, error) {
+	var vib models.VibrationData
+	opts := options.FindOne().SetProjection(bson.M{"velocity_x": 0, "velocity_y": 0, "velocity_z": 0, "waveform": 0})
+	if err := config.GetCollection("vibrations").FindOne(ctx, bson.M{"_id": id}, opts).Decode(&vib); err != nil {
+		return vib, comparedSpectra{}, err
+	}
+	if err := hydrateSpectra(ctx, &vib); err != nil {
+		return vib, comparedSpectra{}, err
+	}
+
+	// Readings stored before spectral metadata fall back to the sensor configuration
+	var sensor models.Sensor
+	if vib.Spectral == nil {
+		config.GetCollection("sensors").FindOne(ctx, bson.M{"serial_number": vib.SerialNumber}).Decode(&sensor)
+	}
+	binWidth, _ := readingAxis(sensor, vib)
+
+	return vib, normalizeSpectra(readingSpectra(vib), binWidth, vib.Spectral), nil
+}
+
+// loadComparedBaseline loads the envelope mean of the baseline of a reading's sensor
+func loadComparedBaseline(ctx context.Context, serialNumber string) (models.Baseline, comparedSpectra, error) {
+	var baseline models.Baseline
+	err := config.GetCollection("baselines").FindOne(ctx, bson.M{"serial_number": serialNumber}).Decode(&baseline)
+	if err != nil {
+		return baseline, comparedSpectra{}, err
+	}
+
+	var binWidth float64
+	if baseline.Spectral != nil && baseline.Spectral.BinWidth > 0 {
+		binWidth = baseline.Spectral.BinWidth
+	} else {
+		var sensor models.Sensor
+		config.GetCollection("sensors").FindOne(ctx, bson.M{"_id": baseline.SensorID}).Decode(&sensor)
+		if lines := len(baseline.Envelope["x"].Mean); sensor.FMax > 0 && lines > 0 {
+			binWidth = sensor.FMax / float64(lines)
+		}
+	}
+
+	spectra := map[string][]float64{}
+	for axis, envelope := range baseline.Envelope {
+		spectra[axis] = envelope.Mean
+	}
+	return baseline, normalizeSpectra(spectra, binWidth, baseline.Spectral), nil
+}
+
+// CompareVibration compares a reading's spectra against another reading
+// (against=<vibration id>) or its sensor's baseline (against=baseline).
+// Spectra with different LOR or FMax are resampled onto a common grid.
+func CompareVibration(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	against := c.Query("against")
+	if against == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "against must be a vibration id or baseline"})
+		return
+	}
+
+	top, err := strconv.Atoi(c.DefaultQuery("top", strconv.Itoa(defaultCompareTop)))
+	if err != nil || top < 0 || top > maxCompareTop {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("top must be between 0 and %d", maxCompareTop)})
+		return
+	}
+
+	axes := []string{"x", "y", "z"}
+	if value := c.Query("axis"); value != "" {
+		axes = strings.Split(value, ",")
+		for _, axis := range axes {
+			if axis != "x" && axis != "y" && axis != "z" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "axis must be x, y or z"})
+				return
+			}
+		}
+	}
+
+	ctx := context.Background()
+	vib, current, err := loadComparedReading(ctx, objectID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vibration data not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var reference comparedSpectra
+	var referenceInfo gin.H
+	if against == "baseline" {
+		var baseline models.Baseline
+		baseline, reference, err = loadComparedBaseline(ctx, vib.SerialNumber)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "baseline not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		referenceInfo = gin.H{"type": "baseline", "id": baseline.ID, "start": baseline.Start, "end": baseline.End}
+	} else {
+		referenceID, err := primitive.ObjectIDFromHex(against)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "against must be a vibration id or baseline"})
+			return
+		}
+		var other models.VibrationData
+		other, reference, err = loadComparedReading(ctx, referenceID)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "reference vibration data not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		referenceInfo = gin.H{"type": "vibration", "id": other.ID, "serial_number": other.SerialNumber, "measured_at": other.MeasuredAt}
+	}
+
+	if current.BinWidth <= 0 || reference.BinWidth <= 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "spectra have no frequency axis; configure the sensor's fmax"})
+		return
+	}
+	if current.Units != reference.Units {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("cannot compare spectra in %s with spectra in %s", current.Units, reference.Units)})
+		return
+	}
+
+	results := map[string]analysis.SpectrumComparison{}
+	for _, axis := range axes {
+		ref, cur := reference.Axes[axis], current.Axes[axis]
+		if len(ref) < 2 || len(cur) < 2 {
+			continue
+		}
+		results[axis] = analysis.CompareSpectra(
+			analysis.Spectrum{BinWidth: reference.BinWidth, Amplitudes: ref},
+			analysis.Spectrum{BinWidth: current.BinWidth, Amplitudes: cur},
+			top,
+		)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reading":   gin.H{"id": vib.ID, "serial_number": vib.SerialNumber, "measured_at": vib.MeasuredAt},
+		"reference": referenceInfo,
+		"units":     current.Units,
+		"scaling":   models.ScalingPeak,
+		"axes":      results,
+	})
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// validateEscalationPolicy checks levels, delays and targets of a policy
+func validateEscalationPolicy(policy models.EscalationPolicy) string {
+	if policy.Organization == "" {
+		return "organization is required"
+	}
+	if policy.MinLevel < 2 || policy.MinLevel > len(defaultWarnings) {
+		return "min_level must be between 2 and 4"
+	}
+	if len(policy.Steps) == 0 {
+		return "steps must contain at least one step"
+	}
+	for _, step := range policy.Steps {
+		if step.DelayMinutes < 0 {
+			return "delay_minutes must not be negative"
+		}
+		if step.TargetType != models.EscalationTargetUser && step.TargetType != models.EscalationTargetSchedule {
+			return "target_type must be user or schedule"
+		}
+		if step.TargetID.IsZero() {
+			return "target_id is required"
+		}
+	}
+	return ""
+}
+
+// CreateEscalationPolicy creates a new escalation policy
+func CreateEscalationPolicy(c *gin.Context) {
+	var policy models.EscalationPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if policy.MinLevel == 0 {
+		policy.MinLevel = 4
+	}
+	if msg := validateEscalationPolicy(policy); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	policy.ID = primitive.NilObjectID
+	policy.CreatedAt = time.Now()
+
+	collection := config.GetCollection("escalation_policies")
+	result, err := collection.InsertOne(context.Background(), policy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create escalation policy"})
+		return
+	}
+
+	policy.ID = result.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, policy)
+}
+
+// GetEscalationPolicies retrieves policies with optional filtering by organization
+func GetEscalationPolicies(c *gin.Context) {
+	policies := []models.EscalationPolicy{}
+	collection := config.GetCollection("escalation_policies")
+
+	filter := bson.M{}
+	if organization := c.Query("organization"); organization != "" {
+		filter["organization"] = organization
+	}
+
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get escalation policies"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &policies); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode escalation policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// GetEscalationPolicy retrieves a policy by ID
+func GetEscalationPolicy(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid escalation policy id"})
+		return
+	}
+
+	var policy models.EscalationPolicy
+	collection := config.GetCollection("escalation_policies")
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&policy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "escalation policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get escalation policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdateEscalationPolicy updates a policy by ID
+func UpdateEscalationPolicy(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid escalation policy id"})
+		return
+	}
+
+	var policy models.EscalationPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := validateEscalationPolicy(policy); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"organization": policy.Organization,
+			"name":         policy.Name,
+			"min_level":    policy.MinLevel,
+			"steps":        policy.Steps,
+		},
+	}
+
+	collection := config.GetCollection("escalation_policies")
+	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update escalation policy"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "escalation policy not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "escalation policy updated successfully"})
+}
+
+// DeleteEscalationPolicy deletes a policy by ID
+func DeleteEscalationPolicy(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid escalation policy id"})
+		return
+	}
+
+	collection := config.GetCollection("escalation_policies")
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete escalation policy"})
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "escalation policy not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "escalation policy deleted successfully"})
+}
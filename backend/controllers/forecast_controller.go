@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/analysis"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultForecastLookbackDays = 30
+	maxForecastLookbackDays     = 365
+
+	// Crossings further out than this are reported as beyond the horizon
+	maxForecastDays = 3650
+
+	defaultUrgentLimit = 10
+	maxUrgentLimit     = 100
+)
+
+// Forecast statuses
+const (
+	forecastExceeded      = "exceeded"
+	forecastProjected     = "projected"
+	forecastBeyond        = "beyond_horizon"
+	forecastStable        = "stable"
+	forecastNoData        = "insufficient_data"
+	forecastNoAlarmThresh = "no_threshold"
+)
+
+var rmsMetrics = []string{"rms_x", "rms_y", "rms_z"}
+
+// forecastOptions selects the history and model used for forecasts
+type forecastOptions struct {
+	Lookback time.Duration
+	Interval string
+	Model    string
+}
+
+// sensorForecast is the projected alarm threshold crossing of a sensor's RMS trend
+type sensorForecast struct {
+	SensorID      primitive.ObjectID `json:"sensor_id"`
+	SerialNumber  string             `json:"serial_number"`
+	Location      string             `json:"location"`
+	AlarmThs      float64            `json:"alarm_ths"`
+	Status        string             `json:"status"`
+	Metric        string             `json:"metric,omitempty"` // Axis whose trend crosses first
+	Current       float64            `json:"current"`          // Mean RMS of the latest bucket
+	Fit           *analysis.TrendFit `json:"fit,omitempty"`
+	CrossingAt    *time.Time         `json:"crossing_at,omitempty"`
+	EarliestAt    *time.Time         `json:"crossing_earliest,omitempty"` // 95% confidence bounds
+	LatestAt      *time.Time         `json:"crossing_latest,omitempty"`
+	DaysRemaining *float64           `json:"days_remaining,omitempty"`
+}
+
+// parseForecastOptions reads lookback_days, interval and model
+func parseForecastOptions(c *gin.Context) (forecastOptions, error) {
+	opts := forecastOptions{
+		Interval: c.DefaultQuery("interval", "day"),
+		Model:    c.DefaultQuery("model", analysis.ModelLinear),
+	}
+
+	days, err := strconv.Atoi(c.DefaultQuery("lookback_days", strconv.Itoa(defaultForecastLookbackDays)))
+	if err != nil || days < 1 || days > maxForecastLookbackDays {
+		return opts, fmt.Errorf("lookback_days must be between 1 and %d", maxForecastLookbackDays)
+	}
+	opts.Lookback = time.Duration(days) * 24 * time.Hour
+
+	if opts.Interval != "hour" && opts.Interval != "day" {
+		return opts, fmt.Errorf("interval must be hour or day")
+	}
+	if opts.Model != analysis.ModelLinear && opts.Model != analysis.ModelExponential {
+		return opts, fmt.Errorf("model must be linear or exponential")
+	}
+	return opts, nil
+}
+
+// forecastTime converts days from now to a time, or nil when out of range
+func forecastTime(now time.Time, days float64) *time.Time {
+	if math.IsInf(days, 0) || math.IsNaN(days) || days > maxForecastDays {
+		return nil
+	}
+	t := now.Add(time.Duration(days * float64(24*time.Hour)))
+	return &t
+}
+
+// forecastSensor fits each axis' bucketed RMS trend and projects when the
+// first one reaches the sensor's alarm threshold. Times are in days from now.
+func forecastSensor(ctx context.Context, sensor models.Sensor, opts forecastOptions) (sensorForecast, error) {
+	forecast := sensorForecast{
+		SensorID:     sensor.ID,
+		SerialNumber: sensor.SerialNumber,
+		Location:     sensor.Location,
+		AlarmThs:     sensor.AlarmThs,
+		Status:       forecastNoData,
+	}
+	if sensor.AlarmThs <= 0 {
+		forecast.Status = forecastNoAlarmThresh
+		return forecast, nil
+	}
+
+	now := time.Now().UTC()
+	buckets, err := aggregateTrend(ctx, sensor.SerialNumber, bson.M{"$gte": now.Add(-opts.Lookback)}, opts.Interval, rmsMetrics, 0)
+	if err != nil {
+		return forecast, err
+	}
+	if len(buckets) == 0 {
+		return forecast, nil
+	}
+
+	x := make([]float64, len(buckets))
+	for i, bucket := range buckets {
+		x[i] = bucket.Time.Sub(now).Hours() / 24
+	}
+
+	series := map[string][]float64{}
+	for _, metric := range rmsMetrics {
+		y := make([]float64, len(buckets))
+		for i, bucket := range buckets {
+			y[i] = bucket.Metrics[metric].Mean
+		}
+		series[metric] = y
+	}
+
+	// The axis furthest along is reported when nothing crosses
+	for _, metric := range rmsMetrics {
+		if current := series[metric][len(buckets)-1]; forecast.Metric == "" || current > forecast.Current {
+			forecast.Metric = metric
+			forecast.Current = current
+		}
+	}
+	if forecast.Current >= sensor.AlarmThs {
+		forecast.Status = forecastExceeded
+		return forecast, nil
+	}
+
+	// Otherwise the axis projected to cross first drives the forecast
+	best := math.Inf(1)
+	for _, metric := range rmsMetrics {
+		fit, err := analysis.FitTrend(x, series[metric], opts.Model)
+		if err != nil {
+			continue
+		}
+		if math.IsInf(best, 1) {
+			forecast.Status = forecastStable
+			if metric == forecast.Metric || forecast.Fit == nil {
+				forecast.Fit = &fit
+			}
+		}
+
+		at, earliest, latest, ok := fit.Crossing(sensor.AlarmThs)
+		if !ok || at >= best {
+			continue
+		}
+		best = at
+
+		// A fit that crossed in the past while the latest value is below the
+		// threshold is treated as crossing now
+		at = math.Max(at, 0)
+		forecast.Metric = metric
+		forecast.Current = series[metric][len(buckets)-1]
+		forecast.Fit = &fit
+		forecast.CrossingAt = forecastTime(now, at)
+		forecast.EarliestAt = forecastTime(now, math.Max(earliest, 0))
+		forecast.LatestAt = forecastTime(now, latest)
+		forecast.DaysRemaining = &at
+		forecast.Status = forecastProjected
+		if forecast.CrossingAt == nil {
+			forecast.Status = forecastBeyond
+			forecast.DaysRemaining = nil
+		}
+	}
+	return forecast, nil
+}
+
+// GetSensorForecast projects when a sensor's RMS trend will reach its alarm threshold
+func GetSensorForecast(c *gin.Context) {
+	sensor, ok := findSensorByParam(c)
+	if !ok {
+		return
+	}
+
+	opts, err := parseForecastOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	forecast, err := forecastSensor(context.Background(), sensor, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, forecast)
+}
+
+// forecastUrgency orders forecasts: exceeded thresholds first, then by
+// projected crossing date
+func forecastUrgency(a, b sensorForecast) bool {
+	if (a.Status == forecastExceeded) != (b.Status == forecastExceeded) {
+		return a.Status == forecastExceeded
+	}
+	if a.Status == forecastExceeded {
+		return a.Current/a.AlarmThs > b.Current/b.AlarmThs
+	}
+	return a.CrossingAt.Before(*b.CrossingAt)
+}
+
+// GetUrgentForecasts lists the sensors of the fleet closest to their alarm
+// threshold. horizon_days limits the list to crossings within that many days.
+func GetUrgentForecasts(c *gin.Context) {
+	opts, err := parseForecastOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultUrgentLimit)))
+	if err != nil || limit < 1 || limit > maxUrgentLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxUrgentLimit)})
+		return
+	}
+
+	horizon := math.Inf(1)
+	if value := c.Query("horizon_days"); value != "" {
+		horizon, err = strconv.ParseFloat(value, 64)
+		if err != nil || horizon <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "horizon_days must be a positive number"})
+			return
+		}
+	}
+
+	filter := bson.M{"alarm_ths": bson.M{"$gt": 0}}
+	if location := c.Query("location"); location != "" {
+		filter["location"] = location
+	}
+
+	ctx := context.Background()
+	cursor, err := config.GetCollection("sensors").Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sensors"})
+		return
+	}
+	var sensors []models.Sensor
+	if err := cursor.All(ctx, &sensors); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode sensors"})
+		return
+	}
+
+	urgent := []sensorForecast{}
+	for _, sensor := range sensors {
+		forecast, err := forecastSensor(ctx, sensor, opts)
+		if err != nil {
+			log.Printf("Forecast error - Sensor %s: %v", sensor.SerialNumber, err)
+			continue
+		}
+		switch forecast.Status {
+		case forecastExceeded:
+		case forecastProjected:
+			if *forecast.DaysRemaining > horizon {
+				continue
+			}
+		default:
+			continue
+		}
+		urgent = append(urgent, forecast)
+	}
+
+	sort.SliceStable(urgent, func(i, j int) bool {
+		return forecastUrgency(urgent[i], urgent[j])
+	})
+	if len(urgent) > limit {
+		urgent = urgent[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"generated_at": time.Now().UTC(),
+		"model":        opts.Model,
+		"data":         urgent,
+	})
+}
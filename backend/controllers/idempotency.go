@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// IdempotencyHeader carries a client-chosen key that makes retries of an
+	// ingest request safe
+	IdempotencyHeader = "Idempotency-Key"
+
+	// ReplayedHeader is set on responses that return an earlier result
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+
+	// A claimed key whose reading never got stored, e.g. because the server
+	// stopped mid-request, is taken over by a retry after this long
+	idempotencyClaimTimeout = 30 * time.Second
+)
+
+var errIngestInProgress = &IngestError{http.StatusConflict, "a request with this message ID is still in progress"}
+
+// ingestKey records which reading a sensor's message ID was stored as.
+// Readings live in a time-series collection that cannot carry unique
+// indexes, so keys are claimed in their own collection, which expires them.
+type ingestKey struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	SerialNumber string             `bson:"serial_number"`
+	Key          string             `bson:"key"`
+	VibrationID  primitive.ObjectID `bson:"vibration_id"`
+	CreatedAt    time.Time          `bson:"created_at"`
+}
+
+// idempotencyKey returns the deduplication key of an ingest request: the
+// Idempotency-Key header, else the message_id sent in the body
+func idempotencyKey(c *gin.Context, messageID string) string {
+	if key := c.GetHeader(IdempotencyHeader); key != "" {
+		return key
+	}
+	return messageID
+}
+
+// claimIngestKey reserves a reading's message ID for its sensor and assigns
+// the reading its ID unless it has one. When the message ID was already
+// stored, the original reading is loaded into vibration and replayed is true.
+func claimIngestKey(ctx context.Context, vibration *models.VibrationData) (replayed bool, err error) {
+	if vibration.MessageID == "" {
+		return false, nil
+	}
+	if err := validateMessageID(vibration.MessageID); err != nil {
+		return false, err
+	}
+
+	if vibration.ID.IsZero() {
+		vibration.ID = primitive.NewObjectID()
+	}
+	keys := config.GetCollection("ingest_keys")
+	_, err = keys.InsertOne(ctx, newIngestKey(*vibration))
+	if err == nil {
+		return false, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	var claim ingestKey
+	err = keys.FindOne(ctx, bson.M{"serial_number": vibration.SerialNumber, "key": vibration.MessageID}).Decode(&claim)
+	if err != nil {
+		return false, err
+	}
+
+	err = loadIngestedReading(ctx, claim, vibration)
+	if err != mongo.ErrNoDocuments {
+		return err == nil, err
+	}
+
+	if time.Since(claim.CreatedAt) < idempotencyClaimTimeout {
+		return false, errIngestInProgress
+	}
+	result, err := keys.UpdateOne(ctx,
+		bson.M{"_id": claim.ID, "vibration_id": claim.VibrationID},
+		bson.M{"$set": bson.M{"vibration_id": vibration.ID, "created_at": time.Now()}})
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, errIngestInProgress
+	}
+	return false, nil
+}
+
+// checkIngestKey is claimIngestKey without the claim, for callers that write
+// the claim themselves. A reading whose claim is not stored yet is an error.
+func checkIngestKey(ctx context.Context, vibration *models.VibrationData) (replayed bool, err error) {
+	if vibration.MessageID == "" {
+		return false, nil
+	}
+	if err := validateMessageID(vibration.MessageID); err != nil {
+		return false, err
+	}
+
+	var claim ingestKey
+	err = config.GetCollection("ingest_keys").FindOne(ctx, bson.M{"serial_number": vibration.SerialNumber, "key": vibration.MessageID}).Decode(&claim)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = loadIngestedReading(ctx, claim, vibration)
+	if err == mongo.ErrNoDocuments {
+		return false, errIngestInProgress
+	}
+	return err == nil, err
+}
+
+func newIngestKey(vibration models.VibrationData) ingestKey {
+	return ingestKey{
+		SerialNumber: vibration.SerialNumber,
+		Key:          vibration.MessageID,
+		VibrationID:  vibration.ID,
+		CreatedAt:    time.Now(),
+	}
+}
+
+func validateMessageID(messageID string) error {
+	if len(messageID) > maxIdempotencyKeyLength {
+		return &IngestError{http.StatusBadRequest, fmt.Sprintf("message ID must be at most %d characters", maxIdempotencyKeyLength)}
+	}
+	return nil
+}
+
+// loadIngestedReading loads the reading stored under a claim into vibration.
+// It returns mongo.ErrNoDocuments while the reading is not stored.
+func loadIngestedReading(ctx context.Context, claim ingestKey, vibration *models.VibrationData) error {
+	var original models.VibrationData
+	err := config.GetCollection("vibrations").FindOne(ctx, bson.M{"_id": claim.VibrationID}).Decode(&original)
+	if err != nil {
+		return err
+	}
+	if err := hydrateSpectra(ctx, &original); err != nil {
+		return err
+	}
+	*vibration = original
+	return nil
+}
+
+// claimedReadingID returns the reading ID a message ID is claimed for
+func claimedReadingID(ctx context.Context, vibration models.VibrationData) (primitive.ObjectID, error) {
+	var claim ingestKey
+	err := config.GetCollection("ingest_keys").FindOne(ctx, bson.M{"serial_number": vibration.SerialNumber, "key": vibration.MessageID}).Decode(&claim)
+	return claim.VibrationID, err
+}
+
+// lostIngestClaims finds the claimed readings whose claim was taken over by a
+// retry while they waited, by position in pending. The retry stores them.
+func lostIngestClaims(ctx context.Context, pending []pendingReading) (map[int]bool, error) {
+	lost := map[int]bool{}
+	var claims bson.A
+	for i, item := range pending {
+		if item.claimed && item.vibration.MessageID != "" {
+			lost[i] = true
+			claims = append(claims, bson.M{
+				"serial_number": item.vibration.SerialNumber,
+				"key":           item.vibration.MessageID,
+				"vibration_id":  item.vibration.ID,
+			})
+		}
+	}
+	if len(claims) == 0 {
+		return lost, nil
+	}
+
+	cursor, err := config.GetCollection("ingest_keys").Find(ctx, bson.M{"$or": claims})
+	if err != nil {
+		return nil, err
+	}
+	var held []ingestKey
+	if err := cursor.All(ctx, &held); err != nil {
+		return nil, err
+	}
+	ids := map[primitive.ObjectID]bool{}
+	for _, claim := range held {
+		ids[claim.VibrationID] = true
+	}
+	for i := range lost {
+		if ids[pending[i].vibration.ID] {
+			delete(lost, i)
+		}
+	}
+	return lost, nil
+}
+
+// releaseIngestKey drops the claim of a reading that could not be stored so
+// that a retry is accepted
+func releaseIngestKey(ctx context.Context, vibration models.VibrationData) {
+	if vibration.MessageID == "" {
+		return
+	}
+	config.GetCollection("ingest_keys").DeleteOne(ctx, bson.M{
+		"serial_number": vibration.SerialNumber,
+		"key":           vibration.MessageID,
+		"vibration_id":  vibration.ID,
+	})
+}
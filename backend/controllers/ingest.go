@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/codec"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IngestError is returned by the ingest pipeline together with the HTTP
+// status it maps to, so that every transport reports the same failures
+type IngestError struct {
+	Status  int
+	Message string
+}
+
+func (e *IngestError) Error() string {
+	return e.Message
+}
+
+// authenticateAPIKey finds the sensor that owns an API key
+func authenticateAPIKey(ctx context.Context, apiKey string) (models.Sensor, error) {
+	if apiKey == "" {
+		return models.Sensor{}, &IngestError{http.StatusBadRequest, "API key is required"}
+	}
+
+	sensor, err := sensorByAPIKey(ctx, apiKey)
+	if err != nil {
+		return sensor, &IngestError{http.StatusUnauthorized, "Invalid API key"}
+	}
+	return sensor, nil
+}
+
+// prepareVibration validates a reading for its sensor and sets the fields
+// owned by the server: serial number, timestamps and spectral metadata
+func prepareVibration(ctx context.Context, sensor models.Sensor, vibration *models.VibrationData, receivedAt time.Time) error {
+	// Validate that the provided serial number matches the sensor's serial number
+	if vibration.SerialNumber != "" && vibration.SerialNumber != sensor.SerialNumber {
+		return &IngestError{http.StatusBadRequest, "Serial number does not match the authenticated sensor"}
+	}
+
+	// Validate required fields
+	if len(vibration.FFTX) == 0 || len(vibration.FFTY) == 0 || len(vibration.FFTZ) == 0 {
+		return &IngestError{http.StatusBadRequest, "FFT data is required for all axes"}
+	}
+
+	if err := applySpectralMetadata(sensor, vibration); err != nil {
+		return err
+	}
+
+	// Set the serial number from the authenticated sensor
+	vibration.ID = primitive.NilObjectID
+	vibration.SerialNumber = sensor.SerialNumber
+	applyTimestamps(vibration, receivedAt)
+	vibration.CreatedAt = vibration.MeasuredAt
+	return checkNotRolledUp(ctx, *vibration)
+}
+
+// annotateVibration marks maintenance suppression and scores the reading
+// against its sensor's baseline. Failures are logged and leave the reading
+// unannotated.
+func annotateVibration(sensor models.Sensor, vibration *models.VibrationData) {
+	if err := markSuppressed(sensor, vibration); err != nil {
+		log.Printf("Maintenance check failed for %s: %v", sensor.SerialNumber, err)
+	}
+	if err := scoreAnomaly(sensor, vibration); err != nil {
+		log.Printf("Anomaly scoring failed for %s: %v", sensor.SerialNumber, err)
+	}
+}
+
+// acceptVibration takes a reading for an authenticated sensor in the
+// configured ingest mode. It returns batchCreated once the reading is stored,
+// ingestQueued once it is queued for storage, and batchDuplicate, with the
+// stored reading loaded into vibration, when its message ID was already
+// stored. Every reading counts against the sensor's rate limit, retries
+// included.
+func acceptVibration(ctx context.Context, sensor models.Sensor, vibration *models.VibrationData) (status string, err error) {
+	if err := limitIngest(ctx, sensor, 1); err != nil {
+		return batchFailed, err
+	}
+	if err := prepareVibration(ctx, sensor, vibration, time.Now()); err != nil {
+		return batchFailed, err
+	}
+	if pipeline != nil {
+		return queueVibration(ctx, sensor, vibration)
+	}
+	return ingestVibration(ctx, sensor, vibration)
+}
+
+// ingestVibration stores a prepared reading and hands it to the downstream
+// processors
+func ingestVibration(ctx context.Context, sensor models.Sensor, vibration *models.VibrationData) (status string, err error) {
+	start := time.Now()
+	if replayed, err := claimIngestKey(ctx, vibration); err != nil || replayed {
+		if replayed {
+			syncMetrics.observe(batchDuplicate, time.Since(start))
+			return batchDuplicate, nil
+		}
+		return batchFailed, err
+	}
+	annotateVibration(sensor, vibration)
+
+	document := storedVibration(ctx, vibration)
+	result, err := config.GetCollection("vibrations").InsertOne(ctx, document)
+	if err != nil {
+		releaseIngestKey(ctx, *vibration)
+		deleteSpectraBlob(ctx, document.SpectraBlob)
+		syncMetrics.observe(batchFailed, time.Since(start))
+		return batchFailed, &IngestError{http.StatusInternalServerError, "Failed to store vibration data"}
+	}
+
+	vibration.ID = result.InsertedID.(primitive.ObjectID)
+	syncMetrics.observe(batchCreated, time.Since(start))
+	processVibration(sensor, *vibration)
+	return batchCreated, nil
+}
+
+// queueVibration assigns a prepared reading its ID, claims its message ID and
+// queues it for the ingest workers. Readings whose message ID was already
+// stored are answered from the database instead, and retries of a reading
+// that is still queued get the queued reading's ID.
+func queueVibration(ctx context.Context, sensor models.Sensor, vibration *models.VibrationData) (status string, err error) {
+	vibration.ID = primitive.NewObjectID()
+	replayed, err := claimIngestKey(ctx, vibration)
+	if err == errIngestInProgress {
+		if id, err := claimedReadingID(ctx, *vibration); err == nil {
+			vibration.ID = id
+			return ingestQueued, nil
+		}
+	}
+	if err != nil {
+		return batchFailed, err
+	}
+	if replayed {
+		return batchDuplicate, nil
+	}
+
+	if err := pipeline.enqueue(sensor, *vibration); err != nil {
+		releaseIngestKey(ctx, *vibration)
+		return batchFailed, err
+	}
+	return ingestQueued, nil
+}
+
+// applyTimestamps sets when a reading was received and when it was measured.
+// Readings without a device time are measured on receipt. A device time
+// outside the accepted skew window is flagged. Old readings keep their device
+// time, since gateways upload what they buffered during an outage; a time in
+// the future cannot be right and is replaced by the receive time.
+func applyTimestamps(vibration *models.VibrationData, receivedAt time.Time) {
+	if vibration.MeasuredAt.IsZero() {
+		vibration.MeasuredAt = vibration.CreatedAt
+	}
+	vibration.ReceivedAt = receivedAt
+	vibration.ClockSkew = ""
+	vibration.DeviceTime = nil
+
+	if vibration.MeasuredAt.IsZero() {
+		vibration.MeasuredAt = receivedAt
+		return
+	}
+
+	cfg := config.GetConfig()
+	if cfg.MaxReadingAge > 0 && vibration.MeasuredAt.Before(receivedAt.Add(-cfg.MaxReadingAge)) {
+		vibration.ClockSkew = models.ClockSkewPast
+		return
+	}
+	if !vibration.MeasuredAt.After(receivedAt.Add(cfg.ClockSkewFuture)) {
+		return
+	}
+	vibration.ClockSkew = models.ClockSkewFuture
+	deviceTime := vibration.MeasuredAt
+	vibration.DeviceTime = &deviceTime
+	vibration.MeasuredAt = receivedAt
+}
+
+// IngestWithAPIKey authenticates a reading by API key and runs it through the
+// same pipeline as POST /:apikey/vibrations. It is used by non-HTTP transports.
+// A redelivered message ID succeeds with the originally stored reading. In
+// async mode queued is true and the reading is stored later.
+func IngestWithAPIKey(ctx context.Context, apiKey string, vibration *models.VibrationData) (queued bool, err error) {
+	sensor, err := authenticateAPIKey(ctx, apiKey)
+	if err != nil {
+		return false, err
+	}
+	status, err := acceptVibration(ctx, sensor, vibration)
+	return status == ingestQueued, err
+}
+
+// ingestRequest checks a reading received over HTTP against its sensor's
+// payload quota, accepts it and writes the response: the stored reading, or
+// 202 Accepted with its ID when it was queued
+func ingestRequest(c *gin.Context, sensor models.Sensor, vibration models.VibrationData) {
+	err := checkPayloadQuota(c, sensor)
+	status := batchFailed
+	if err == nil {
+		status, err = acceptVibration(context.Background(), sensor, &vibration)
+	}
+
+	switch {
+	case err != nil:
+		respondIngestError(c, err)
+	case status == ingestQueued:
+		c.JSON(http.StatusAccepted, gin.H{
+			"id":            vibration.ID.Hex(),
+			"serial_number": vibration.SerialNumber,
+			"message_id":    vibration.MessageID,
+			"measured_at":   vibration.MeasuredAt,
+			"received_at":   vibration.ReceivedAt,
+			"status":        ingestQueued,
+		})
+	default:
+		if status == batchDuplicate {
+			c.Header(ReplayedHeader, "true")
+		}
+		c.JSON(http.StatusCreated, vibration)
+	}
+}
+
+// ingestErrorStatus returns the HTTP status for an ingest pipeline error
+func ingestErrorStatus(err error) int {
+	switch err := err.(type) {
+	case *IngestError:
+		return err.Status
+	case *RateLimitError:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// decompressRequest replaces the request body with its decoded form when the
+// client sent a gzip or zstd Content-Encoding, counting the bytes read for
+// checkPayloadQuota
+func decompressRequest(c *gin.Context) error {
+	body, err := codec.Decompress(c.Request.Body, c.GetHeader("Content-Encoding"), config.GetConfig().MaxIngestBodyBytes)
+	if err != nil {
+		return &IngestError{http.StatusUnsupportedMediaType, err.Error()}
+	}
+	counter := &payloadCounter{ReadCloser: body, limit: c.GetInt64(payloadLimitKey)}
+	c.Set(payloadCounterKey, counter)
+	c.Request.Body = counter
+	return nil
+}
+
+// bindVibration decodes a single reading from a JSON or binary frame body,
+// chosen by Content-Type
+func bindVibration(c *gin.Context, vibration *models.VibrationData) error {
+	if err := decompressRequest(c); err != nil {
+		return err
+	}
+
+	var err error
+	if c.ContentType() == codec.FrameContentType {
+		*vibration, err = codec.DecodeFrame(c.Request.Body)
+	} else {
+		err = c.ShouldBindJSON(vibration)
+	}
+	if err != nil {
+		return bindError(err)
+	}
+
+	vibration.MessageID = idempotencyKey(c, vibration.MessageID)
+	return nil
+}
+
+// bindError maps body decoding failures to ingest errors
+func bindError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, codec.ErrBodyTooLarge) {
+		return &IngestError{http.StatusRequestEntityTooLarge, err.Error()}
+	}
+	return &IngestError{http.StatusBadRequest, err.Error()}
+}
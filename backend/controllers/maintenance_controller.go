@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// windowActiveAt reports whether t falls inside the window or one of its recurrences
+func windowActiveAt(window models.MaintenanceWindow, t time.Time) bool {
+	if t.Before(window.Start) {
+		return false
+	}
+
+	var period time.Duration
+	switch window.Recurrence {
+	case models.RecurrenceDaily:
+		period = 24 * time.Hour
+	case models.RecurrenceWeekly:
+		period = 7 * 24 * time.Hour
+	default:
+		return t.Before(window.End)
+	}
+
+	occurrence := window.Start.Add(t.Sub(window.Start) / period * period)
+	if window.RecurrenceUntil != nil && occurrence.After(*window.RecurrenceUntil) {
+		return false
+	}
+	return t.Before(occurrence.Add(window.End.Sub(window.Start)))
+}
+
+// activeMaintenanceWindow returns the maintenance window covering the sensor at t, if any
+func activeMaintenanceWindow(ctx context.Context, sensor models.Sensor, t time.Time) (*models.MaintenanceWindow, error) {
+	scope := bson.A{bson.M{"serial_numbers": sensor.SerialNumber}}
+	if sensor.Location != "" {
+		scope = append(scope, bson.M{"location": sensor.Location})
+	}
+
+	collection := config.GetCollection("maintenance_windows")
+	cursor, err := collection.Find(ctx, bson.M{
+		"$or":   scope,
+		"start": bson.M{"$lte": t},
+		"$and": bson.A{bson.M{"$or": bson.A{
+			bson.M{"end": bson.M{"$gt": t}},
+			bson.M{"recurrence": bson.M{"$in": bson.A{models.RecurrenceDaily, models.RecurrenceWeekly}}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var window models.MaintenanceWindow
+		if err := cursor.Decode(&window); err != nil {
+			return nil, err
+		}
+		if windowActiveAt(window, t) {
+			return &window, nil
+		}
+	}
+	return nil, cursor.Err()
+}
+
+// markSuppressed flags a reading taken during a maintenance window so that
+// it is stored but does not raise alarms
+func markSuppressed(sensor models.Sensor, vibration *models.VibrationData) error {
+	window, err := activeMaintenanceWindow(context.Background(), sensor, vibration.MeasuredAt)
+	if err != nil {
+		return err
+	}
+	if window != nil {
+		vibration.Suppressed = true
+		vibration.MaintenanceWindowID = &window.ID
+	}
+	return nil
+}
+
+// validateMaintenanceWindow checks scope, time range and recurrence
+func validateMaintenanceWindow(window models.MaintenanceWindow) string {
+	if len(window.SerialNumbers) == 0 && window.Location == "" {
+		return "serial_numbers or location is required"
+	}
+	if window.Start.IsZero() || !window.End.After(window.Start) {
+		return "end must be after start"
+	}
+	switch window.Recurrence {
+	case models.RecurrenceNone:
+	case models.RecurrenceDaily:
+		if window.End.Sub(window.Start) > 24*time.Hour {
+			return "daily windows cannot be longer than one day"
+		}
+	case models.RecurrenceWeekly:
+		if window.End.Sub(window.Start) > 7*24*time.Hour {
+			return "weekly windows cannot be longer than one week"
+		}
+	default:
+		return "recurrence must be daily or weekly"
+	}
+	return ""
+}
+
+// CreateMaintenanceWindow schedules a maintenance window
+func CreateMaintenanceWindow(c *gin.Context) {
+	var window models.MaintenanceWindow
+	if err := c.ShouldBindJSON(&window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := validateMaintenanceWindow(window); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	insertMaintenanceWindow(c, window)
+}
+
+// SilenceSensors creates an ad-hoc maintenance window starting now
+func SilenceSensors(c *gin.Context) {
+	var request struct {
+		SerialNumbers []string `json:"serial_numbers"`
+		Location      string   `json:"location"`
+		Minutes       int      `json:"minutes" binding:"required"`
+		Reason        string   `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Minutes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minutes must be positive"})
+		return
+	}
+
+	now := time.Now()
+	window := models.MaintenanceWindow{
+		Name:          "Silence",
+		Reason:        request.Reason,
+		SerialNumbers: request.SerialNumbers,
+		Location:      request.Location,
+		Start:         now,
+		End:           now.Add(time.Duration(request.Minutes) * time.Minute),
+	}
+
+	if msg := validateMaintenanceWindow(window); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	insertMaintenanceWindow(c, window)
+}
+
+func insertMaintenanceWindow(c *gin.Context, window models.MaintenanceWindow) {
+	if window.SerialNumbers == nil {
+		window.SerialNumbers = []string{}
+	}
+	window.ID = primitive.NilObjectID
+	window.CreatedAt = time.Now()
+
+	collection := config.GetCollection("maintenance_windows")
+	result, err := collection.InsertOne(context.Background(), window)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create maintenance window"})
+		return
+	}
+
+	window.ID = result.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, window)
+}
+
+// GetMaintenanceWindows retrieves maintenance windows, optionally only those active now
+func GetMaintenanceWindows(c *gin.Context) {
+	windows := []models.MaintenanceWindow{}
+	collection := config.GetCollection("maintenance_windows")
+
+	filter := bson.M{}
+	if serialNumber := c.Query("serial_number"); serialNumber != "" {
+		filter["serial_numbers"] = serialNumber
+	}
+	if location := c.Query("location"); location != "" {
+		filter["location"] = location
+	}
+
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get maintenance windows"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &windows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode maintenance windows"})
+		return
+	}
+
+	if c.Query("active") == "true" {
+		now := time.Now()
+		active := []models.MaintenanceWindow{}
+		for _, window := range windows {
+			if windowActiveAt(window, now) {
+				active = append(active, window)
+			}
+		}
+		windows = active
+	}
+
+	c.JSON(http.StatusOK, windows)
+}
+
+// GetMaintenanceWindow retrieves a maintenance window by ID
+func GetMaintenanceWindow(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maintenance window id"})
+		return
+	}
+
+	var window models.MaintenanceWindow
+	collection := config.GetCollection("maintenance_windows")
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&window)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "maintenance window not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get maintenance window"})
+		return
+	}
+
+	c.JSON(http.StatusOK, window)
+}
+
+// UpdateMaintenanceWindow updates a maintenance window by ID
+func UpdateMaintenanceWindow(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maintenance window id"})
+		return
+	}
+
+	var window models.MaintenanceWindow
+	if err := c.ShouldBindJSON(&window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := validateMaintenanceWindow(window); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if window.SerialNumbers == nil {
+		window.SerialNumbers = []string{}
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":             window.Name,
+			"reason":           window.Reason,
+			"serial_numbers":   window.SerialNumbers,
+			"location":         window.Location,
+			"start":            window.Start,
+			"end":              window.End,
+			"recurrence":       window.Recurrence,
+			"recurrence_until": window.RecurrenceUntil,
+		},
+	}
+
+	collection := config.GetCollection("maintenance_windows")
+	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update maintenance window"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "maintenance window not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "maintenance window updated successfully"})
+}
+
+// DeleteMaintenanceWindow deletes a maintenance window, ending any suppression it applies
+func DeleteMaintenanceWindow(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maintenance window id"})
+		return
+	}
+
+	collection := config.GetCollection("maintenance_windows")
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete maintenance window"})
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "maintenance window not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "maintenance window deleted successfully"})
+}
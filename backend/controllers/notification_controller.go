@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetNotificationSettings returns a user's phone and notification preferences
+func GetNotificationSettings(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var user models.User
+	collection := config.GetCollection("users")
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&user)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"email":                    user.Email,
+		"phone":                    user.Phone,
+		"notification_preferences": user.NotificationPreferences,
+	})
+}
+
+// UpdateNotificationSettings updates a user's phone and notification preferences
+func UpdateNotificationSettings(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var request struct {
+		Phone                   string                         `json:"phone"`
+		NotificationPreferences models.NotificationPreferences `json:"notification_preferences"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs := request.NotificationPreferences
+	if prefs.MinLevel == 0 {
+		prefs.MinLevel = 1
+	}
+	if prefs.MinLevel < 1 || prefs.MinLevel > len(defaultWarnings) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_level must be between 1 and 4"})
+		return
+	}
+	if prefs.SMSEnabled && request.Phone == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone is required to enable SMS"})
+		return
+	}
+	if quiet := prefs.QuietHours; quiet != nil {
+		if _, err := time.Parse("15:04", quiet.Start); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quiet_hours.start must be HH:MM"})
+			return
+		}
+		if _, err := time.Parse("15:04", quiet.End); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quiet_hours.end must be HH:MM"})
+			return
+		}
+		if _, err := time.LoadLocation(quiet.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quiet_hours.timezone"})
+			return
+		}
+	}
+
+	collection := config.GetCollection("users")
+	update := bson.M{
+		"$set": bson.M{
+			"phone":                    request.Phone,
+			"notification_preferences": prefs,
+		},
+	}
+
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": objectID},
+		update,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification settings updated successfully"})
+}
@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// currentOnCall returns the user on call at t, honouring overrides before the rotation
+func currentOnCall(schedule models.OnCallSchedule, t time.Time) (primitive.ObjectID, bool) {
+	for _, override := range schedule.Overrides {
+		if !t.Before(override.Start) && t.Before(override.End) {
+			return override.UserID, true
+		}
+	}
+
+	if len(schedule.UserIDs) == 0 || schedule.ShiftHours <= 0 || t.Before(schedule.RotationStart) {
+		return primitive.NilObjectID, false
+	}
+
+	shift := time.Duration(schedule.ShiftHours) * time.Hour
+	index := int(t.Sub(schedule.RotationStart)/shift) % len(schedule.UserIDs)
+	return schedule.UserIDs[index], true
+}
+
+// validateSchedule checks that a schedule can produce an on-call user
+func validateSchedule(schedule models.OnCallSchedule) string {
+	if schedule.Organization == "" {
+		return "organization is required"
+	}
+	if len(schedule.UserIDs) == 0 {
+		return "user_ids must contain at least one user"
+	}
+	if schedule.ShiftHours <= 0 {
+		return "shift_hours must be positive"
+	}
+	if schedule.RotationStart.IsZero() {
+		return "rotation_start is required"
+	}
+	for _, override := range schedule.Overrides {
+		if !override.End.After(override.Start) {
+			return "override end must be after start"
+		}
+	}
+	return ""
+}
+
+// CreateOnCallSchedule creates a new on-call rotation for an organization
+func CreateOnCallSchedule(c *gin.Context) {
+	var schedule models.OnCallSchedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := validateSchedule(schedule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if schedule.Overrides == nil {
+		schedule.Overrides = []models.OnCallOverride{}
+	}
+	schedule.ID = primitive.NilObjectID
+	schedule.CreatedAt = time.Now()
+
+	collection := config.GetCollection("oncall_schedules")
+	result, err := collection.InsertOne(context.Background(), schedule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create schedule"})
+		return
+	}
+
+	schedule.ID = result.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// GetOnCallSchedules retrieves schedules with optional filtering by organization
+func GetOnCallSchedules(c *gin.Context) {
+	schedules := []models.OnCallSchedule{}
+	collection := config.GetCollection("oncall_schedules")
+
+	filter := bson.M{}
+	if organization := c.Query("organization"); organization != "" {
+		filter["organization"] = organization
+	}
+
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get schedules"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &schedules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+// GetOnCallSchedule retrieves a schedule by ID
+func GetOnCallSchedule(c *gin.Context) {
+	schedule, ok := findOnCallSchedule(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// GetCurrentOnCall returns who is on call now, or at the time given by the "at" query
+func GetCurrentOnCall(c *gin.Context) {
+	schedule, ok := findOnCallSchedule(c)
+	if !ok {
+		return
+	}
+
+	at := time.Now()
+	if value := c.Query("at"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at must be RFC3339"})
+			return
+		}
+		at = t
+	}
+
+	userID, found := currentOnCall(schedule, at)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "nobody is on call at this time"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedule_id": schedule.ID,
+		"user_id":     userID,
+		"at":          at,
+	})
+}
+
+// UpdateOnCallSchedule replaces a schedule's rotation and overrides
+func UpdateOnCallSchedule(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"})
+		return
+	}
+
+	var schedule models.OnCallSchedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := validateSchedule(schedule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if schedule.Overrides == nil {
+		schedule.Overrides = []models.OnCallOverride{}
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"organization":   schedule.Organization,
+			"name":           schedule.Name,
+			"user_ids":       schedule.UserIDs,
+			"rotation_start": schedule.RotationStart,
+			"shift_hours":    schedule.ShiftHours,
+			"overrides":      schedule.Overrides,
+		},
+	}
+
+	collection := config.GetCollection("oncall_schedules")
+	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update schedule"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "schedule updated successfully"})
+}
+
+// AddOnCallOverride adds an override to a schedule
+func AddOnCallOverride(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"})
+		return
+	}
+
+	var override models.OnCallOverride
+	if err := c.ShouldBindJSON(&override); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if override.UserID.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+	if !override.End.After(override.Start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "override end must be after start"})
+		return
+	}
+
+	collection := config.GetCollection("oncall_schedules")
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": objectID},
+		bson.M{"$push": bson.M{"overrides": override}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add override"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, override)
+}
+
+// DeleteOnCallSchedule deletes a schedule by ID
+func DeleteOnCallSchedule(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"})
+		return
+	}
+
+	collection := config.GetCollection("oncall_schedules")
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete schedule"})
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "schedule deleted successfully"})
+}
+
+// findOnCallSchedule loads the schedule named by the id path parameter and
+// writes the error response itself when it cannot
+func findOnCallSchedule(c *gin.Context) (models.OnCallSchedule, bool) {
+	var schedule models.OnCallSchedule
+
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"})
+		return schedule, false
+	}
+
+	collection := config.GetCollection("oncall_schedules")
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&schedule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "schedule not found"})
+			return schedule, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get schedule"})
+		return schedule, false
+	}
+
+	return schedule, true
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageLimit = 10
+	maxPageLimit     = 100
+)
+
+// pageCursor is the position of a row in a (sort field, _id) ordered list.
+// It is sent to clients base64 encoded and should be treated as opaque.
+type pageCursor struct {
+	Time time.Time          `json:"t"`
+	ID   primitive.ObjectID `json:"id"`
+	Prev bool               `json:"p,omitempty"` // Page backwards from this position
+}
+
+func (p pageCursor) encode() string {
+	data, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// pageRequest holds the pagination parameters of a list request. Lists are
+// returned newest first, ordered by sortField and then _id; an empty
+// sortField orders by _id alone.
+type pageRequest struct {
+	sortField string
+	limit     int
+	skip      int64 // Legacy ?page= offset, only used without a cursor
+	number    int   // Legacy 1-based page number
+	cursor    *pageCursor
+	count     bool
+
+	// Whether the client asked for pages with a cursor or limit; lists that
+	// used to return everything still do without them
+	paged bool
+}
+
+// parsePageRequest reads limit, cursor, page and count=true. Limits above
+// maxPageLimit are lowered to it.
+func parsePageRequest(c *gin.Context, sortField string) (pageRequest, error) {
+	page := pageRequest{
+		sortField: sortField,
+		number:    1,
+		count:     c.Query("count") == "true",
+		paged:     c.Query("cursor") != "" || c.Query("limit") != "",
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit < 1 {
+		return page, fmt.Errorf("limit must be a positive integer")
+	}
+	page.limit = min(limit, maxPageLimit)
+
+	if value := c.Query("cursor"); value != "" {
+		if page.cursor, err = decodeCursor(value); err != nil {
+			return page, err
+		}
+		return page, nil
+	}
+
+	// Offset paging is kept for existing clients; cursors are preferred
+	if value := c.Query("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return page, fmt.Errorf("page must be a positive integer")
+		}
+		page.number = n
+		page.skip = int64(n-1) * int64(page.limit)
+	}
+	return page, nil
+}
+
+// keysetFilter restricts the filter to rows after (or before) the cursor
+func (p pageRequest) keysetFilter(filter bson.M) bson.M {
+	if p.cursor == nil {
+		return filter
+	}
+
+	// Newest first, so the next page holds smaller keys
+	op := "$lt"
+	if p.cursor.Prev {
+		op = "$gt"
+	}
+
+	var keyset bson.M
+	if p.sortField == "" {
+		keyset = bson.M{"_id": bson.M{op: p.cursor.ID}}
+	} else {
+		keyset = bson.M{"$or": bson.A{
+			bson.M{p.sortField: bson.M{op: p.cursor.Time}},
+			bson.M{p.sortField: p.cursor.Time, "_id": bson.M{op: p.cursor.ID}},
+		}}
+	}
+
+	combined := bson.M{}
+	for key, value := range filter {
+		combined[key] = value
+	}
+	combined["$and"] = bson.A{keyset}
+	return combined
+}
+
+func (p pageRequest) sort() bson.D {
+	direction := -1
+	if p.cursor != nil && p.cursor.Prev {
+		direction = 1
+	}
+	if p.sortField == "" {
+		return bson.D{{Key: "_id", Value: direction}}
+	}
+	return bson.D{{Key: p.sortField, Value: direction}, {Key: "_id", Value: direction}}
+}
+
+// findAll loads every row in list order, for requests that are not paged
+func findAll[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, page pageRequest) ([]T, error) {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(page.sort()))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := []T{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// findPage loads one page of rows and builds the pagination block with
+// next/prev cursors. key returns the cursor position of a row.
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, page pageRequest, projection bson.M, key func(T) pageCursor) ([]T, gin.H, error) {
+	// One extra row tells whether another page follows
+	opts := options.Find().
+		SetSort(page.sort()).
+		SetLimit(int64(page.limit + 1))
+	if page.cursor == nil && page.skip > 0 {
+		opts.SetSkip(page.skip)
+	}
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := collection.Find(ctx, page.keysetFilter(filter), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := []T{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, nil, err
+	}
+
+	more := len(items) > page.limit
+	if more {
+		items = items[:page.limit]
+	}
+
+	backwards := page.cursor != nil && page.cursor.Prev
+	if backwards {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	hasNext, hasPrev := more, page.cursor != nil || page.skip > 0
+	if backwards {
+		hasNext, hasPrev = true, more
+	}
+
+	pagination := gin.H{"limit": page.limit, "next_cursor": nil, "prev_cursor": nil}
+	if len(items) > 0 {
+		if hasNext {
+			next := key(items[len(items)-1])
+			pagination["next_cursor"] = next.encode()
+		}
+		if hasPrev {
+			prev := key(items[0])
+			prev.Prev = true
+			pagination["prev_cursor"] = prev.encode()
+		}
+	}
+
+	if page.count {
+		total, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		pagination["total"] = total
+	}
+
+	return items, pagination, nil
+}
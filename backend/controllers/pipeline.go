@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IngestModeSync  = "sync"
+	IngestModeAsync = "async"
+
+	// Status of a reading accepted for asynchronous storage
+	ingestQueued = "queued"
+
+	// Latest store latencies kept for percentiles
+	latencySamples = 1024
+)
+
+var (
+	errIngestQueueFull    = &IngestError{http.StatusServiceUnavailable, "Ingest queue is full"}
+	errIngestShuttingDown = &IngestError{http.StatusServiceUnavailable, "Server is shutting down"}
+)
+
+// ingestProcessor runs on every stored reading. Processors run in the order
+// listed, and a sensor's readings reach them in the order they were stored.
+type ingestProcessor struct {
+	name    string
+	process func(sensor models.Sensor, vibration models.VibrationData)
+}
+
+var ingestProcessors = []ingestProcessor{
+	{"stream", publishVibration},
+	{"alarms", evaluateAlarm},
+}
+
+// processVibration hands a stored reading to the downstream processors. A
+// processor that panics is logged and does not stop the ones after it.
+func processVibration(sensor models.Sensor, vibration models.VibrationData) {
+	for _, processor := range ingestProcessors {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Ingest processor %s failed for %s: %v", processor.name, sensor.SerialNumber, r)
+				}
+			}()
+			processor.process(sensor, vibration)
+		}()
+	}
+}
+
+// queuedReading is a validated reading waiting for a worker
+type queuedReading struct {
+	sensor     models.Sensor
+	vibration  models.VibrationData
+	enqueuedAt time.Time
+}
+
+// ingestPipeline stores queued readings in the background. Each worker has
+// its own queue and a sensor always maps to the same worker, so its readings
+// are stored and processed in order.
+type ingestPipeline struct {
+	queues    []chan queuedReading
+	batchSize int
+	batchWait time.Duration
+	metrics   ingestMetrics
+
+	mu      sync.RWMutex // Held for reading while enqueueing
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// pipeline is nil in sync mode
+var pipeline *ingestPipeline
+
+// syncMetrics counts readings stored on the request goroutine
+var syncMetrics = &ingestMetrics{}
+
+// StartIngestPipeline starts the workers of the async ingest mode. In sync
+// mode it does nothing.
+func StartIngestPipeline(cfg *config.Config) error {
+	switch cfg.IngestMode {
+	case "", IngestModeSync:
+		return nil
+	case IngestModeAsync:
+	default:
+		return fmt.Errorf("unknown ingest mode %q, expected sync or async", cfg.IngestMode)
+	}
+	if cfg.IngestWorkers < 1 || cfg.IngestBatchSize < 1 || cfg.IngestQueueSize < cfg.IngestWorkers {
+		return fmt.Errorf("async ingest needs at least one worker, a batch size of one and a queue slot per worker")
+	}
+
+	p := &ingestPipeline{
+		queues:    make([]chan queuedReading, cfg.IngestWorkers),
+		batchSize: cfg.IngestBatchSize,
+		batchWait: cfg.IngestBatchWait,
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan queuedReading, cfg.IngestQueueSize/cfg.IngestWorkers)
+		p.workers.Add(1)
+		go p.work(p.queues[i])
+	}
+	pipeline = p
+	return nil
+}
+
+// StopIngestPipeline stops accepting readings and waits until the workers
+// have stored the queued ones, or until ctx is done
+func StopIngestPipeline(ctx context.Context) error {
+	p := pipeline
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		depth, _ := p.depth()
+		return fmt.Errorf("%d queued readings not stored: %v", depth, ctx.Err())
+	}
+}
+
+// enqueue queues a reading without blocking. A full queue drops it.
+func (p *ingestPipeline) enqueue(sensor models.Sensor, vibration models.VibrationData) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errIngestShuttingDown
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(sensor.SerialNumber))
+	queue := p.queues[hash.Sum32()%uint32(len(p.queues))]
+
+	select {
+	case queue <- queuedReading{sensor: sensor, vibration: vibration, enqueuedAt: time.Now()}:
+		p.metrics.enqueued.Add(1)
+		return nil
+	default:
+		p.metrics.dropped.Add(1)
+		return errIngestQueueFull
+	}
+}
+
+// work stores a queue's readings in batches: a batch is written once it is
+// full or batchWait after its first reading arrived. It returns once the
+// queue is closed and empty.
+func (p *ingestPipeline) work(queue chan queuedReading) {
+	defer p.workers.Done()
+	for first := range queue {
+		batch := []queuedReading{first}
+		timer := time.NewTimer(p.batchWait)
+	collect:
+		for len(batch) < p.batchSize {
+			select {
+			case item, ok := <-queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, item)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		p.store(batch)
+	}
+}
+
+func (p *ingestPipeline) store(batch []queuedReading) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pending := make([]pendingReading, len(batch))
+	for i, item := range batch {
+		pending[i] = pendingReading{index: i, sensor: item.sensor, vibration: item.vibration, claimed: true}
+	}
+
+	p.metrics.batches.Add(1)
+	insertReadings(ctx, pending, func(item *pendingReading, status string, err error) {
+		p.metrics.observe(status, time.Since(batch[item.index].enqueuedAt))
+		if err != nil {
+			log.Printf("Async ingest failed for %s: %v", item.sensor.SerialNumber, err)
+			return
+		}
+		if status == batchCreated {
+			processVibration(item.sensor, item.vibration)
+		}
+	})
+}
+
+// depth returns the number of queued readings and the queue capacity
+func (p *ingestPipeline) depth() (int, int) {
+	depth, capacity := 0, 0
+	for _, queue := range p.queues {
+		depth += len(queue)
+		capacity += cap(queue)
+	}
+	return depth, capacity
+}
+
+// ingestMetrics counts readings by outcome and samples how long they took to
+// store: from enqueueing in async mode, from validation in sync mode
+type ingestMetrics struct {
+	enqueued   atomic.Int64
+	dropped    atomic.Int64
+	stored     atomic.Int64
+	duplicates atomic.Int64
+	failed     atomic.Int64
+	batches    atomic.Int64
+
+	mu        sync.Mutex
+	latencies [latencySamples]time.Duration
+	samples   int
+	max       time.Duration
+}
+
+func (m *ingestMetrics) observe(status string, latency time.Duration) {
+	switch status {
+	case batchCreated:
+		m.stored.Add(1)
+	case batchDuplicate:
+		m.duplicates.Add(1)
+	default:
+		m.failed.Add(1)
+	}
+
+	m.mu.Lock()
+	m.latencies[m.samples%latencySamples] = latency
+	m.samples++
+	if latency > m.max {
+		m.max = latency
+	}
+	m.mu.Unlock()
+}
+
+// latency returns percentiles of the sampled latencies in milliseconds
+func (m *ingestMetrics) latency() gin.H {
+	m.mu.Lock()
+	samples := make([]time.Duration, min(m.samples, latencySamples))
+	copy(samples, m.latencies[:len(samples)])
+	max := m.max
+	m.mu.Unlock()
+
+	if len(samples) == 0 {
+		return gin.H{"samples": 0}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(p float64) float64 {
+		return milliseconds(samples[int(p*float64(len(samples)-1))])
+	}
+	return gin.H{
+		"samples": len(samples),
+		"p50_ms":  percentile(0.5),
+		"p95_ms":  percentile(0.95),
+		"p99_ms":  percentile(0.99),
+		"max_ms":  milliseconds(max),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// GetIngestMetrics reports the ingest mode, queue depth, reading counts,
+// store latency and the sensor cache hit rate
+func GetIngestMetrics(c *gin.Context) {
+	if pipeline == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"mode":       IngestModeSync,
+			"stored":     syncMetrics.stored.Load(),
+			"duplicates": syncMetrics.duplicates.Load(),
+			"failed":     syncMetrics.failed.Load(),
+			"latency":    syncMetrics.latency(),
+
+			"sensor_cache": cachedSensors.stats(),
+		})
+		return
+	}
+
+	depth, capacity := pipeline.depth()
+	c.JSON(http.StatusOK, gin.H{
+		"mode":           IngestModeAsync,
+		"workers":        len(pipeline.queues),
+		"queue_depth":    depth,
+		"queue_capacity": capacity,
+		"enqueued":       pipeline.metrics.enqueued.Load(),
+		"dropped":        pipeline.metrics.dropped.Load(),
+		"stored":         pipeline.metrics.stored.Load(),
+		"duplicates":     pipeline.metrics.duplicates.Load(),
+		"failed":         pipeline.metrics.failed.Load(),
+		"batches":        pipeline.metrics.batches.Load(),
+		"latency":        pipeline.metrics.latency(),
+
+		"sensor_cache": cachedSensors.stats(),
+	})
+}
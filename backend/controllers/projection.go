@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fields of a reading that may be requested with ?fields=
+var vibrationFields = []string{
+	"serial_number", "measured_at", "created_at", "received_at", "clock_skew", "device_time",
+	"fft_x", "fft_y", "fft_z", "spectral",
+	"rms_x", "rms_y", "rms_z",
+	"peak_x", "peak_y", "peak_z",
+	"crest_x", "crest_y", "crest_z",
+	"velocity_x", "velocity_y", "velocity_z", "waveform", "spectra_blob",
+	"anomaly", "suppressed", "maintenance_window_id",
+}
+
+// Array fields left out of the summary view
+var spectrumFields = []string{"fft_x", "fft_y", "fft_z", "velocity_x", "velocity_y", "velocity_z", "waveform"}
+
+// vibrationView selects which fields of a reading are loaded and returned.
+// A nil view means the full document.
+type vibrationView struct {
+	projection bson.M
+	keep       map[string]bool
+}
+
+// parseVibrationView reads ?fields=rms_x,peak_x or ?view=summary. id,
+// serial_number, measured_at and created_at are always returned.
+func parseVibrationView(c *gin.Context) (*vibrationView, error) {
+	if value := c.Query("fields"); value != "" {
+		view := &vibrationView{
+			projection: bson.M{"serial_number": 1, "measured_at": 1, "created_at": 1},
+			keep:       map[string]bool{"id": true, "serial_number": true, "measured_at": true, "created_at": true},
+		}
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if !containsString(vibrationFields, field) {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+			view.projection[field] = 1
+			view.keep[field] = true
+			if containsString(spectrumFields, field) {
+				view.projection["spectra_blob"] = 1
+			}
+		}
+		return view, nil
+	}
+
+	switch c.DefaultQuery("view", "full") {
+	case "full":
+		return nil, nil
+	case "summary":
+		view := &vibrationView{projection: bson.M{}, keep: map[string]bool{"id": true}}
+		for _, field := range vibrationFields {
+			view.keep[field] = !containsString(spectrumFields, field)
+		}
+		for _, field := range spectrumFields {
+			view.projection[field] = 0
+		}
+		return view, nil
+	}
+	return nil, fmt.Errorf("view must be full or summary")
+}
+
+// includesSpectra reports whether the view returns any array that may be
+// offloaded to object storage
+func (v *vibrationView) includesSpectra() bool {
+	if v == nil {
+		return true
+	}
+	for _, field := range spectrumFields {
+		if v.keep[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// render keeps only the selected fields of a reading, adding the frequency
+// axis when requested and the spectral metadata was loaded
+func (v *vibrationView) render(vibration models.VibrationData, withAxis bool) (interface{}, error) {
+	if v == nil {
+		if withAxis {
+			return withFrequencyAxis(vibration), nil
+		}
+		return vibration, nil
+	}
+
+	data, err := json.Marshal(vibration)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for key := range doc {
+		if !v.keep[key] {
+			delete(doc, key)
+		}
+	}
+	if withAxis && vibration.Spectral != nil {
+		doc["frequency_axis"] = frequencyAxis(vibration.Spectral)
+	}
+	return doc, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetVibrationSpectrum returns the spectrum of a single axis of a reading.
+// type=velocity returns the velocity spectrum of waveform-derived readings.
+func GetVibrationSpectrum(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	axis := c.Param("axis")
+	if axis != "x" && axis != "y" && axis != "z" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "axis must be x, y or z"})
+		return
+	}
+
+	field, units := "fft_"+axis, ""
+	switch c.DefaultQuery("type", "acceleration") {
+	case "acceleration":
+	case "velocity":
+		field, units = "velocity_"+axis, models.UnitsMMPerSec
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be acceleration or velocity"})
+		return
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"serial_number": 1, "measured_at": 1, "created_at": 1, "spectral": 1, "spectra_blob": 1, field: 1})
+	var vib models.VibrationData
+	err = config.GetCollection("vibrations").FindOne(context.Background(), bson.M{"_id": objectID}, opts).Decode(&vib)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vibration data not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := hydrateSpectra(context.Background(), &vib); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	spectra := map[string][]float64{
+		"fft_x": vib.FFTX, "fft_y": vib.FFTY, "fft_z": vib.FFTZ,
+		"velocity_x": vib.VelocityX, "velocity_y": vib.VelocityY, "velocity_z": vib.VelocityZ,
+	}
+	amplitudes := spectra[field]
+	if amplitudes == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "spectrum not available for this reading"})
+		return
+	}
+	if units == "" && vib.Spectral != nil {
+		units = vib.Spectral.Units
+	}
+
+	response := gin.H{
+		"id":            vib.ID,
+		"serial_number": vib.SerialNumber,
+		"measured_at":   vib.MeasuredAt,
+		"created_at":    vib.CreatedAt,
+		"axis":          axis,
+		"units":         units,
+		"spectral":      vib.Spectral,
+		"amplitudes":    amplitudes,
+	}
+	if c.Query("frequency_axis") == "true" {
+		response["frequency_axis"] = frequencyAxis(vib.Spectral)
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/ratelimit"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How long a sensor owner's organization is remembered for rate limiting
+const organizationCacheTTL = 5 * time.Minute
+
+// RateLimitError is returned when a sensor or its organization has sent more
+// readings than its quota allows
+type RateLimitError struct {
+	Scope      string // "sensor" or "organization"
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry in %d seconds", e.Scope, e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds returns the wait in whole seconds for a Retry-After header
+func (e *RateLimitError) RetryAfterSeconds() int {
+	return int(math.Max(1, math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// sensorLimit returns the reading rate a sensor may ingest at
+func sensorLimit(sensor models.Sensor) ratelimit.Limit {
+	cfg := config.GetConfig()
+	perMinute := cfg.SensorReadingsPerMinute
+	if sensor.Quota != nil && sensor.Quota.MaxReadingsPerMinute > 0 {
+		perMinute = sensor.Quota.MaxReadingsPerMinute
+	}
+	return ratelimit.PerMinute(perMinute, cfg.RateLimitBurst)
+}
+
+func organizationLimit() ratelimit.Limit {
+	cfg := config.GetConfig()
+	return ratelimit.PerMinute(cfg.OrgReadingsPerMinute, cfg.RateLimitBurst)
+}
+
+func sensorRateKey(sensor models.Sensor) string {
+	return "sensor:" + sensor.ID.Hex()
+}
+
+type cachedOrganization struct {
+	key     string
+	expires time.Time
+}
+
+var organizationCache = struct {
+	sync.Mutex
+	entries map[primitive.ObjectID]cachedOrganization
+}{entries: map[primitive.ObjectID]cachedOrganization{}}
+
+// organizationRateKey returns the bucket shared by the sensors of the owner's
+// organization. Owners without an organization form one of their own.
+func organizationRateKey(ctx context.Context, sensor models.Sensor) string {
+	organizationCache.Lock()
+	entry, ok := organizationCache.entries[sensor.UserID]
+	organizationCache.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.key
+	}
+
+	key := "user:" + sensor.UserID.Hex()
+	var user models.User
+	err := config.GetCollection("users").FindOne(ctx, bson.M{"_id": sensor.UserID}).Decode(&user)
+	if err != nil {
+		return key
+	}
+	if user.Organization != "" {
+		key = "organization:" + user.Organization
+	}
+
+	organizationCache.Lock()
+	organizationCache.entries[sensor.UserID] = cachedOrganization{key: key, expires: time.Now().Add(organizationCacheTTL)}
+	organizationCache.Unlock()
+	return key
+}
+
+// limitIngest takes n readings from the sensor's and its organization's
+// buckets. When a bucket turns them away, those already taken from are
+// refunded. A limiter that fails is logged and lets the readings through.
+func limitIngest(ctx context.Context, sensor models.Sensor, n int) error {
+	limiter := ratelimit.Default()
+	buckets := []struct {
+		scope string
+		key   string
+		limit ratelimit.Limit
+	}{
+		{"sensor", sensorRateKey(sensor), sensorLimit(sensor)},
+		{"organization", organizationRateKey(ctx, sensor), organizationLimit()},
+	}
+	for i, bucket := range buckets {
+		result, err := limiter.Take(ctx, bucket.key, bucket.limit, n)
+		if err != nil {
+			log.Printf("Rate limit check failed for %s: %v", sensor.SerialNumber, err)
+			continue
+		}
+		if result.Allowed {
+			continue
+		}
+
+		for _, taken := range buckets[:i] {
+			if err := limiter.Refund(ctx, taken.key, taken.limit, n); err != nil {
+				log.Printf("Rate limit refund failed for %s: %v", sensor.SerialNumber, err)
+			}
+		}
+		return &RateLimitError{Scope: bucket.scope, RetryAfter: result.RetryAfter}
+	}
+	return nil
+}
+
+// payloadCounter counts the decoded bytes read from a request body and stops
+// reading once they exceed limit, when set
+type payloadCounter struct {
+	io.ReadCloser
+	n     int64
+	limit int64
+}
+
+var errPayloadQuota = errors.New("payload exceeds the sensor's quota")
+
+func (p *payloadCounter) Read(b []byte) (int, error) {
+	if p.limit > 0 && p.n > p.limit {
+		return 0, errPayloadQuota
+	}
+	n, err := p.ReadCloser.Read(b)
+	p.n += int64(n)
+	return n, err
+}
+
+const (
+	payloadCounterKey = "payload_counter"
+	payloadLimitKey   = "payload_limit"
+)
+
+// limitPayload makes the request body stop after the sensor's payload quota,
+// for routes that know the sensor before reading the body
+func limitPayload(c *gin.Context, sensor models.Sensor) {
+	if sensor.Quota != nil && sensor.Quota.MaxPayloadBytes > 0 {
+		c.Set(payloadLimitKey, sensor.Quota.MaxPayloadBytes)
+	}
+}
+
+// checkPayloadQuota rejects a request whose decoded body was larger than the
+// sensor's payload quota
+func checkPayloadQuota(c *gin.Context, sensor models.Sensor) error {
+	if sensor.Quota == nil || sensor.Quota.MaxPayloadBytes <= 0 {
+		return nil
+	}
+	counter, ok := c.Get(payloadCounterKey)
+	if !ok || counter.(*payloadCounter).n <= sensor.Quota.MaxPayloadBytes {
+		return nil
+	}
+	return &IngestError{http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("Payload exceeds the sensor's quota of %d bytes", sensor.Quota.MaxPayloadBytes)}
+}
+
+// respondIngestError writes an ingest error, telling rate limited clients
+// and those turned away by a full queue when to retry
+func respondIngestError(c *gin.Context, err error) {
+	if limitErr, ok := err.(*RateLimitError); ok {
+		c.Header("Retry-After", strconv.Itoa(limitErr.RetryAfterSeconds()))
+	}
+	if err == errIngestQueueFull || err == errIngestShuttingDown {
+		c.Header("Retry-After", "1")
+	}
+	c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+}
+
+// GetSensorUsage reports a sensor's ingest limits and how much of them it
+// and its organization have used
+func GetSensorUsage(c *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sensor id"})
+		return
+	}
+
+	ctx := context.Background()
+	var sensor models.Sensor
+	if err := config.GetCollection("sensors").FindOne(ctx, bson.M{"_id": objectID}).Decode(&sensor); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
+		return
+	}
+
+	limiter := ratelimit.Default()
+	describe := func(key string, limit ratelimit.Limit) (gin.H, error) {
+		usage, err := limiter.Usage(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{
+			"key":                 key,
+			"readings_per_minute": math.Round(limit.Rate * 60),
+			"burst":               math.Floor(limit.Burst),
+			"usage":               usage,
+		}, nil
+	}
+
+	sensorUsage, err := describe(sensorRateKey(sensor), sensorLimit(sensor))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get usage"})
+		return
+	}
+	if sensor.Quota != nil {
+		sensorUsage["max_payload_bytes"] = sensor.Quota.MaxPayloadBytes
+	}
+	organizationUsage, err := describe(organizationRateKey(ctx, sensor), organizationLimit())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sensor":       sensorUsage,
+		"organization": organizationUsage,
+	})
+}
@@ -0,0 +1,480 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Raw readings deleted per request by retention
+const retentionDeleteBatch = 1000
+
+// retentionState records up to when a sensor's raw readings have been rolled up
+type retentionState struct {
+	SerialNumber string    `bson:"serial_number"`
+	RolledUntil  time.Time `bson:"rolled_until"`
+}
+
+// checkNotRolledUp rejects a reading measured before its sensor's
+// rolled_until. Retention would delete it on its next run without adding it
+// to a rollup.
+func checkNotRolledUp(ctx context.Context, vibration models.VibrationData) error {
+	// Retention keeps at least a day of raw readings
+	if vibration.MeasuredAt.After(time.Now().Add(-24 * time.Hour)) {
+		return nil
+	}
+
+	rolledUntil, err := sensorRolledUntil(ctx, vibration.SerialNumber)
+	if err != nil {
+		return &IngestError{http.StatusInternalServerError, "Failed to check retention state"}
+	}
+	if vibration.MeasuredAt.Before(rolledUntil) {
+		return &IngestError{http.StatusUnprocessableEntity,
+			fmt.Sprintf("Reading measured before %s, which retention has already rolled up", rolledUntil.Format(time.RFC3339))}
+	}
+	return nil
+}
+
+// sensorRolledUntil returns up to when a sensor's raw readings have been
+// rolled up, or the zero time when retention has not compacted it
+func sensorRolledUntil(ctx context.Context, serialNumber string) (time.Time, error) {
+	var state retentionState
+	err := config.GetCollection("retention_state").FindOne(ctx, bson.M{"serial_number": serialNumber}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return state.RolledUntil, err
+}
+
+// compactionResult summarizes one retention run for a sensor
+type compactionResult struct {
+	SerialNumber   string `json:"serial_number"`
+	RollupsWritten int    `json:"rollups_written"`
+	RawDeleted     int64  `json:"raw_deleted"`
+	RollupsDeleted int64  `json:"rollups_deleted"`
+}
+
+// validateRetentionPolicy checks scope and tier lengths of a policy
+func validateRetentionPolicy(policy models.RetentionPolicy) string {
+	if (policy.Organization == "") == (policy.SerialNumber == "") {
+		return "exactly one of organization or serial_number is required"
+	}
+	if policy.RawDays < 0 || policy.HourlyDays < 0 || policy.DailyDays < 0 {
+		return "retention days must not be negative"
+	}
+	if policy.RawDays == 0 && (policy.HourlyDays > 0 || policy.DailyDays > 0) {
+		return "raw_days is required when rollups expire; rollups are only written for expired raw readings"
+	}
+	if policy.HourlyDays > 0 && policy.HourlyDays < policy.RawDays {
+		return "hourly_days must be 0 or at least raw_days"
+	}
+	if policy.DailyDays > 0 && (policy.HourlyDays == 0 || policy.DailyDays < policy.HourlyDays) {
+		return "daily_days must be 0 or at least hourly_days"
+	}
+	return ""
+}
+
+// StartRetentionScheduler periodically applies retention policies
+func StartRetentionScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if _, err := runRetention(ctx, time.Now().UTC()); err != nil {
+			log.Printf("Retention error: %v", err)
+		}
+		cancel()
+	}
+}
+
+// runRetention compacts every sensor covered by a retention policy
+func runRetention(ctx context.Context, now time.Time) ([]compactionResult, error) {
+	cursor, err := config.GetCollection("retention_policies").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var policies []models.RetentionPolicy
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	bySerial := map[string]models.RetentionPolicy{}
+	byOrganization := map[string]models.RetentionPolicy{}
+	for _, policy := range policies {
+		if policy.SerialNumber != "" {
+			bySerial[policy.SerialNumber] = policy
+		} else {
+			byOrganization[policy.Organization] = policy
+		}
+	}
+
+	cursor, err = config.GetCollection("sensors").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var sensors []models.Sensor
+	if err := cursor.All(ctx, &sensors); err != nil {
+		return nil, err
+	}
+
+	// Sensors belong to an organization through their owner
+	organizations := map[primitive.ObjectID]string{}
+	if len(byOrganization) > 0 {
+		cursor, err = config.GetCollection("users").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"organization": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var users []models.User
+		if err := cursor.All(ctx, &users); err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			organizations[user.ID] = user.Organization
+		}
+	}
+
+	results := []compactionResult{}
+	for _, sensor := range sensors {
+		policy, ok := bySerial[sensor.SerialNumber]
+		if !ok {
+			policy, ok = byOrganization[organizations[sensor.UserID]]
+		}
+		if !ok {
+			continue
+		}
+
+		result, err := compactSensor(ctx, sensor.SerialNumber, policy, now)
+		if err != nil {
+			log.Printf("Retention error - Sensor %s: %v", sensor.SerialNumber, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// compactSensor rolls up a sensor's raw readings older than the policy's
+// raw tier into hourly and daily rollups, deletes them, and expires old
+// rollups. Readings referenced by an alarm are kept.
+//
+// Rollups cover whole days between the previous and the current cutoff, so
+// each bucket is written once from complete data. Ingest rejects readings
+// measured before the cutoff, see checkNotRolledUp.
+func compactSensor(ctx context.Context, serialNumber string, policy models.RetentionPolicy, now time.Time) (compactionResult, error) {
+	result := compactionResult{SerialNumber: serialNumber}
+	if policy.RawDays == 0 {
+		return result, nil
+	}
+
+	cutoff := now.AddDate(0, 0, -policy.RawDays).Truncate(24 * time.Hour)
+
+	states := config.GetCollection("retention_state")
+	var state retentionState
+	err := states.FindOne(ctx, bson.M{"serial_number": serialNumber}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return result, err
+	}
+
+	if cutoff.After(state.RolledUntil) {
+		dateFilter := bson.M{"$lt": cutoff}
+		if !state.RolledUntil.IsZero() {
+			dateFilter["$gte"] = state.RolledUntil
+		}
+
+		rollups := config.GetCollection("vibration_rollups")
+		for _, interval := range []string{models.RollupHour, models.RollupDay} {
+			buckets, err := aggregateTrend(ctx, serialNumber, dateFilter, interval, trendMetrics, 0)
+			if err != nil {
+				return result, err
+			}
+			for _, bucket := range buckets {
+				rollup := models.VibrationRollup{
+					SerialNumber: serialNumber,
+					Interval:     interval,
+					Time:         bucket.Time,
+					Count:        bucket.Count,
+					Metrics:      bucket.Metrics,
+				}
+				_, err := rollups.ReplaceOne(ctx,
+					bson.M{"serial_number": serialNumber, "interval": interval, "time": bucket.Time},
+					rollup, options.Replace().SetUpsert(true))
+				if err != nil {
+					return result, err
+				}
+				result.RollupsWritten++
+			}
+		}
+
+		_, err = states.UpdateOne(ctx, bson.M{"serial_number": serialNumber},
+			bson.M{"$set": bson.M{"rolled_until": cutoff}}, options.Update().SetUpsert(true))
+		if err != nil {
+			return result, err
+		}
+		state.RolledUntil = cutoff
+	}
+
+	result.RawDeleted, err = deleteRolledReadings(ctx, serialNumber, state.RolledUntil)
+	if err != nil {
+		return result, err
+	}
+
+	tiers := map[string]int{models.RollupHour: policy.HourlyDays, models.RollupDay: policy.DailyDays}
+	for interval, days := range tiers {
+		if days == 0 {
+			continue
+		}
+		expired, err := config.GetCollection("vibration_rollups").DeleteMany(ctx, bson.M{
+			"serial_number": serialNumber,
+			"interval":      interval,
+			"time":          bson.M{"$lt": now.AddDate(0, 0, -days)},
+		})
+		if err != nil {
+			return result, err
+		}
+		result.RollupsDeleted += expired.DeletedCount
+	}
+
+	return result, nil
+}
+
+// deleteRolledReadings deletes a sensor's readings measured before until,
+// keeping those an alarm refers to. Readings are found with a lookup on
+// alarms and deleted in batches by ID.
+func deleteRolledReadings(ctx context.Context, serialNumber string, until time.Time) (int64, error) {
+	vibrations := config.GetCollection("vibrations")
+	cursor, err := vibrations.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"serial_number": serialNumber, "measured_at": bson.M{"$lt": until}}},
+		{"$project": bson.M{"_id": 1}},
+		{"$lookup": bson.M{"from": "alarms", "localField": "_id", "foreignField": "vibration_id", "as": "alarms"}},
+		{"$match": bson.M{"alarms": bson.M{"$size": 0}}},
+		{"$project": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var deleted int64
+	ids := bson.A{}
+	flush := func() error {
+		filter := bson.M{"_id": bson.M{"$in": ids}}
+		if err := deleteSpectraBlobs(ctx, filter); err != nil {
+			return err
+		}
+		result, err := vibrations.DeleteMany(ctx, filter)
+		if err != nil {
+			return err
+		}
+		deleted += result.DeletedCount
+		ids = bson.A{}
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return deleted, err
+		}
+		ids = append(ids, doc.ID)
+		if len(ids) == retentionDeleteBatch {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return deleted, err
+	}
+	if len(ids) > 0 {
+		if err := flush(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// RunRetention applies retention policies immediately instead of waiting for the scheduler
+func RunRetention(c *gin.Context) {
+	results, err := runRetention(c.Request.Context(), time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if results == nil {
+		results = []compactionResult{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+// CreateRetentionPolicy creates a new retention policy
+func CreateRetentionPolicy(c *gin.Context) {
+	var policy models.RetentionPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msg := validateRetentionPolicy(policy); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	policy.ID = primitive.NilObjectID
+	policy.CreatedAt = time.Now()
+
+	collection := config.GetCollection("retention_policies")
+	result, err := collection.InsertOne(context.Background(), policy)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a retention policy already exists for this scope"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create retention policy"})
+		return
+	}
+
+	policy.ID = result.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, policy)
+}
+
+// GetRetentionPolicies retrieves policies with optional filtering by organization
+func GetRetentionPolicies(c *gin.Context) {
+	policies := []models.RetentionPolicy{}
+	collection := config.GetCollection("retention_policies")
+
+	filter := bson.M{}
+	if organization := c.Query("organization"); organization != "" {
+		filter["organization"] = organization
+	}
+	if serialNumber := c.Query("serial_number"); serialNumber != "" {
+		filter["serial_number"] = serialNumber
+	}
+
+	cursor, err := collection.Find(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get retention policies"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	if err = cursor.All(context.Background(), &policies); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode retention policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// GetRetentionPolicy retrieves a policy by ID
+func GetRetentionPolicy(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid retention policy id"})
+		return
+	}
+
+	var policy models.RetentionPolicy
+	collection := config.GetCollection("retention_policies")
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&policy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "retention policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get retention policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdateRetentionPolicy updates the tiers of a policy by ID; the scope cannot change
+func UpdateRetentionPolicy(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid retention policy id"})
+		return
+	}
+
+	var policy models.RetentionPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	collection := config.GetCollection("retention_policies")
+	var existing models.RetentionPolicy
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&existing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "retention policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get retention policy"})
+		return
+	}
+	policy.Organization = existing.Organization
+	policy.SerialNumber = existing.SerialNumber
+
+	if msg := validateRetentionPolicy(policy); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":        policy.Name,
+			"raw_days":    policy.RawDays,
+			"hourly_days": policy.HourlyDays,
+			"daily_days":  policy.DailyDays,
+		},
+	}
+
+	if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": objectID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update retention policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "retention policy updated successfully"})
+}
+
+// DeleteRetentionPolicy deletes a policy by ID. Data already compacted is not restored.
+func DeleteRetentionPolicy(c *gin.Context) {
+	id := c.Param("id")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid retention policy id"})
+		return
+	}
+
+	collection := config.GetCollection("retention_policies")
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete retention policy"})
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "retention policy not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "retention policy deleted successfully"})
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoDB error code for change streams on a server that is not a replica set
+const changeStreamNotSupported = 40573
+
+type cachedSensor struct {
+	sensor  models.Sensor
+	keys    []string
+	expires time.Time
+	element *list.Element
+}
+
+// sensorCache keeps recently used sensors for the ingest path, found by
+// serial number or by a hash of the API key. Entries expire after the
+// configured TTL and the least recently used go first when the cache is full.
+type sensorCache struct {
+	mu      sync.Mutex
+	entries map[primitive.ObjectID]*cachedSensor
+	keys    map[string]*cachedSensor
+	lru     *list.List // Sensor IDs, most recently used first
+
+	// Bumped by every invalidation so that lookups that started before it
+	// do not cache what they read
+	generation uint64
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	evictions     atomic.Int64
+	invalidations atomic.Int64
+}
+
+var cachedSensors = newSensorCache()
+
+func newSensorCache() *sensorCache {
+	return &sensorCache{
+		entries: map[primitive.ObjectID]*cachedSensor{},
+		keys:    map[string]*cachedSensor{},
+		lru:     list.New(),
+	}
+}
+
+func serialCacheKey(serialNumber string) string {
+	return "serial:" + serialNumber
+}
+
+func apiKeyCacheKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return "api_key:" + hex.EncodeToString(sum[:])
+}
+
+// sensorBySerialNumber finds a sensor like FindOne on serial_number
+func sensorBySerialNumber(ctx context.Context, serialNumber string) (models.Sensor, error) {
+	return cachedSensors.find(ctx, serialCacheKey(serialNumber), bson.M{"serial_number": serialNumber})
+}
+
+// sensorByAPIKey finds a sensor like FindOne on api_key
+func sensorByAPIKey(ctx context.Context, apiKey string) (models.Sensor, error) {
+	return cachedSensors.find(ctx, apiKeyCacheKey(apiKey), bson.M{"api_key": apiKey})
+}
+
+func (c *sensorCache) find(ctx context.Context, key string, filter bson.M) (models.Sensor, error) {
+	cfg := config.GetConfig()
+	var sensor models.Sensor
+	if cfg.SensorCacheSize <= 0 {
+		err := config.GetCollection("sensors").FindOne(ctx, filter).Decode(&sensor)
+		return sensor, err
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.keys[key]; ok && time.Now().Before(entry.expires) {
+		c.lru.MoveToFront(entry.element)
+		sensor = entry.sensor
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return sensor, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	if err := config.GetCollection("sensors").FindOne(ctx, filter).Decode(&sensor); err != nil {
+		return sensor, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.store(sensor, time.Now().Add(cfg.SensorCacheTTL), cfg.SensorCacheSize)
+	}
+	c.mu.Unlock()
+	return sensor, nil
+}
+
+// store caches a sensor, evicting the least recently used beyond size.
+// The caller holds mu.
+func (c *sensorCache) store(sensor models.Sensor, expires time.Time, size int) {
+	c.remove(sensor.ID)
+
+	entry := &cachedSensor{sensor: sensor, expires: expires}
+	if sensor.SerialNumber != "" {
+		entry.keys = append(entry.keys, serialCacheKey(sensor.SerialNumber))
+	}
+	if sensor.APIKey != "" {
+		entry.keys = append(entry.keys, apiKeyCacheKey(sensor.APIKey))
+	}
+	entry.element = c.lru.PushFront(sensor.ID)
+	c.entries[sensor.ID] = entry
+	for _, key := range entry.keys {
+		c.keys[key] = entry
+	}
+
+	for c.lru.Len() > size {
+		c.remove(c.lru.Back().Value.(primitive.ObjectID))
+		c.evictions.Add(1)
+	}
+}
+
+// remove drops a sensor's entry. The caller holds mu.
+func (c *sensorCache) remove(id primitive.ObjectID) {
+	entry, ok := c.entries[id]
+	if !ok {
+		return
+	}
+	delete(c.entries, id)
+	c.lru.Remove(entry.element)
+	for _, key := range entry.keys {
+		if c.keys[key] == entry {
+			delete(c.keys, key)
+		}
+	}
+}
+
+// invalidate drops a sensor that was changed or deleted
+func (c *sensorCache) invalidate(id primitive.ObjectID) {
+	c.mu.Lock()
+	c.generation++
+	c.remove(id)
+	c.mu.Unlock()
+	c.invalidations.Add(1)
+}
+
+// clear drops every sensor, for when changes may have been missed
+func (c *sensorCache) clear() {
+	c.mu.Lock()
+	c.generation++
+	c.entries = map[primitive.ObjectID]*cachedSensor{}
+	c.keys = map[string]*cachedSensor{}
+	c.lru.Init()
+	c.mu.Unlock()
+	c.invalidations.Add(1)
+}
+
+// stats reports the cache's size and hit rate
+func (c *sensorCache) stats() gin.H {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	hits, misses := c.hits.Load(), c.misses.Load()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	return gin.H{
+		"size":          size,
+		"capacity":      config.GetConfig().SensorCacheSize,
+		"hits":          hits,
+		"misses":        misses,
+		"hit_rate":      hitRate,
+		"evictions":     c.evictions.Load(),
+		"invalidations": c.invalidations.Load(),
+	}
+}
+
+// WatchSensorChanges drops cached sensors as soon as any instance changes
+// them, by following a change stream on sensors. Change streams need a
+// replica set; without one, or while the stream is down, entries still
+// expire after the TTL.
+func WatchSensorChanges(ctx context.Context) {
+	delay := time.Second
+	for {
+		opened, err := watchSensors(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == changeStreamNotSupported {
+			log.Printf("Sensor change stream not supported, cached sensors expire after %v: %v", config.GetConfig().SensorCacheTTL, err)
+			return
+		}
+		log.Printf("Sensor change stream error: %v", err)
+
+		if opened {
+			delay = time.Second
+		}
+		time.Sleep(delay)
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+}
+
+// watchSensors follows the sensors change stream until it fails. opened
+// reports whether the stream was established.
+func watchSensors(ctx context.Context) (opened bool, err error) {
+	stream, err := config.GetCollection("sensors").Watch(ctx, mongo.Pipeline{})
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close(ctx)
+
+	// Changes made while no stream was open were missed
+	cachedSensors.clear()
+
+	for stream.Next(ctx) {
+		var event struct {
+			OperationType string `bson:"operationType"`
+			DocumentKey   struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			return true, err
+		}
+
+		switch event.OperationType {
+		case "insert", "update", "replace", "delete":
+			cachedSensors.invalidate(event.DocumentKey.ID)
+		default:
+			// The collection was dropped or renamed
+			cachedSensors.clear()
+		}
+	}
+	return true, stream.Err()
+}
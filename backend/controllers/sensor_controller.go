@@ -42,45 +42,56 @@ func generateSensorCredentials() (string, string, error) {
 	return token, apiKey, nil
 }
 
-// CreateSensor creates a new sensor
-func CreateSensor(c *gin.Context) {
-	var sensor models.Sensor
-	if err := c.ShouldBindJSON(&sensor); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
+// prepareSensor validates a new sensor and sets its creation time. The
+// unique index on serial_number catches sensors created concurrently.
+func prepareSensor(ctx context.Context, sensor *models.Sensor) error {
 	// Set creation time
 	sensor.CreatedAt = time.Now()
 
 	// Validate user_id
 	if sensor.UserID.IsZero() {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
-		return
+		return &itemError{http.StatusBadRequest, "user_id is required"}
+	}
+	if sensor.SerialNumber == "" {
+		return &itemError{http.StatusBadRequest, "serial_number is required"}
 	}
 
 	// Check if user exists
 	userCollection := config.GetCollection("users")
 	var user models.User
-	err := userCollection.FindOne(context.Background(), bson.M{"_id": sensor.UserID}).Decode(&user)
+	err := userCollection.FindOne(ctx, bson.M{"_id": sensor.UserID}).Decode(&user)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
-		return
+		return &itemError{http.StatusBadRequest, "invalid user_id"}
 	}
 
 	// Check if serial number is unique
 	sensorCollection := config.GetCollection("sensors")
 	var existingSensor models.Sensor
-	err = sensorCollection.FindOne(context.Background(), bson.M{"serial_number": sensor.SerialNumber}).Decode(&existingSensor)
+	err = sensorCollection.FindOne(ctx, bson.M{"serial_number": sensor.SerialNumber}).Decode(&existingSensor)
 	if err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "serial number already exists"})
+		return &itemError{http.StatusConflict, "serial number already exists"}
+	}
+	return nil
+}
+
+// CreateSensor creates a new sensor
+func CreateSensor(c *gin.Context) {
+	var sensor models.Sensor
+	if err := c.ShouldBindJSON(&sensor); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := prepareSensor(context.Background(), &sensor); err != nil {
+		c.JSON(itemErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	// Insert sensor
-	result, err := sensorCollection.InsertOne(context.Background(), sensor)
+	result, err := config.GetCollection("sensors").InsertOne(context.Background(), sensor)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create sensor"})
+		err = insertError(err, "serial number already exists", "failed to create sensor")
+		c.JSON(itemErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -92,9 +103,14 @@ func CreateSensor(c *gin.Context) {
 
 // GetSensors retrieves all sensors with optional filtering
 func GetSensors(c *gin.Context) {
-	var sensors []models.Sensor
 	sensorCollection := config.GetCollection("sensors")
 
+	page, err := parsePageRequest(c, "created_at")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Build filter
 	filter := bson.M{}
 	if userID := c.Query("user_id"); userID != "" {
@@ -103,20 +119,30 @@ func GetSensors(c *gin.Context) {
 		}
 	}
 
-	// Find sensors
-	cursor, err := sensorCollection.Find(context.Background(), filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sensors"})
+	// Without a cursor or limit, every sensor is returned as a bare array
+	if !page.paged {
+		sensors, err := findAll[models.Sensor](context.Background(), sensorCollection, filter, page)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sensors"})
+			return
+		}
+		c.JSON(http.StatusOK, sensors)
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	if err = cursor.All(context.Background(), &sensors); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode sensors"})
+	// Find sensors
+	sensors, pagination, err := findPage(context.Background(), sensorCollection, filter, page, nil, func(sensor models.Sensor) pageCursor {
+		return pageCursor{Time: sensor.CreatedAt, ID: sensor.ID}
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get sensors"})
 		return
 	}
 
-	c.JSON(http.StatusOK, sensors)
+	c.JSON(http.StatusOK, gin.H{
+		"data":       sensors,
+		"pagination": pagination,
+	})
 }
 
 // GetSensor retrieves a sensor by ID
@@ -174,6 +200,11 @@ func UpdateSensor(c *gin.Context) {
 			"lor":           sensor.LOR,
 			"g_max":         sensor.GMax,
 			"alarm_ths":     sensor.AlarmThs,
+
+			"running_speed_rpm": sensor.RunningSpeedRPM,
+			"bearing":           sensor.Bearing,
+			"anomaly_threshold": sensor.AnomalyThreshold,
+			"quota":             sensor.Quota,
 		},
 	}
 
@@ -192,6 +223,7 @@ func UpdateSensor(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
 		return
 	}
+	cachedSensors.invalidate(objectID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "sensor updated successfully"})
 }
@@ -216,6 +248,7 @@ func DeleteSensor(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
 		return
 	}
+	cachedSensors.invalidate(objectID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "sensor deleted successfully"})
 }
@@ -251,7 +284,9 @@ func generateTokenHex(length int) (string, error) {
 	return hex.EncodeToString(tokenBytes), nil
 }
 
-// BatchCreateSensors creates multiple sensors
+// BatchCreateSensors creates multiple sensors, validating each like
+// CreateSensor and reporting each one's outcome. With ?atomic=true the
+// sensors are created together or not at all.
 func BatchCreateSensors(c *gin.Context) {
 	var sensors []models.Sensor
 	if err := c.ShouldBindJSON(&sensors); err != nil {
@@ -259,36 +294,68 @@ func BatchCreateSensors(c *gin.Context) {
 		return
 	}
 
-	collection := config.GetCollection("sensors")
-	var results []models.Sensor
-	var errors []string
+	report, err := newBatchReport(c, len(sensors))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	for _, sensor := range sensors {
-		result, err := collection.InsertOne(context.Background(), sensor)
+	ctx := context.Background()
+	serialNumbers := map[string]bool{}
+	var valid []int
+	for i := range sensors {
+		sensor := &sensors[i]
+		err := prepareSensor(ctx, sensor)
+		if err == nil && serialNumbers[sensor.SerialNumber] {
+			err = &itemError{http.StatusConflict, "serial number appears earlier in the batch"}
+		}
+		serialNumbers[sensor.SerialNumber] = true
+		if err == nil {
+			// Batches provision devices in bulk, so every sensor comes back
+			// with the token and API key it ingests with
+			if sensor.Token, sensor.APIKey, err = generateSensorCredentials(); err != nil {
+				err = &itemError{http.StatusInternalServerError, "failed to generate credentials"}
+			}
+		}
 		if err != nil {
-			errors = append(errors, "Error creating sensor: "+sensor.SerialNumber)
+			report.fail(i, err)
 			continue
 		}
-
-		sensor.ID = result.InsertedID.(primitive.ObjectID)
-		results = append(results, sensor)
-	}
-
-	response := gin.H{
-		"successful_registrations": len(results),
-		"failed_registrations":     len(errors),
-		"sensors":                  results,
+		sensor.ID = primitive.NewObjectID()
+		valid = append(valid, i)
 	}
 
-	if len(errors) > 0 {
-		response["errors"] = errors
+	collection := config.GetCollection("sensors")
+	switch {
+	case !report.atomic:
+		for _, i := range valid {
+			if _, err := collection.InsertOne(ctx, sensors[i]); err != nil {
+				report.fail(i, insertError(err, "serial number already exists", "failed to create sensor"))
+				continue
+			}
+			report.succeed(i, batchCreated, sensors[i].ID, sensors[i])
+		}
+	case report.failed():
+		report.abort()
+	case len(valid) > 0:
+		documents := make([]interface{}, len(valid))
+		for k, i := range valid {
+			documents[k] = sensors[i]
+		}
+		err := withTransaction(ctx, func(sc mongo.SessionContext) error {
+			_, err := collection.InsertMany(sc, documents)
+			return err
+		})
+		if err != nil {
+			report.failInsert(valid, err, "serial number already exists", "failed to create sensors")
+			break
+		}
+		for _, i := range valid {
+			report.succeed(i, batchCreated, sensors[i].ID, sensors[i])
+		}
 	}
 
-	if len(results) > 0 {
-		c.JSON(http.StatusCreated, response)
-	} else {
-		c.JSON(http.StatusBadRequest, response)
-	}
+	report.respond(c)
 }
 
 // RegisterSensor registers a sensor and generates credentials
@@ -349,6 +416,7 @@ func RegisterSensor(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Sensor not found during update"})
 		return
 	}
+	cachedSensors.invalidate(sensor.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"token":     tokenString,
@@ -404,6 +472,7 @@ func GenerateSensorToken(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
 		return
 	}
+	cachedSensors.invalidate(objectID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"token": token,
@@ -468,6 +537,7 @@ func RevokeSensorToken(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
 		return
 	}
+	cachedSensors.invalidate(objectID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "token revoked successfully"})
 }
@@ -511,6 +581,7 @@ func RegenerateSensorCredentials(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
 		return
 	}
+	cachedSensors.invalidate(objectID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"credentials": gin.H{
@@ -582,6 +653,7 @@ func RevokeSensorCredentials(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "sensor not found"})
 		return
 	}
+	cachedSensors.invalidate(objectID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "credentials revoked successfully"})
 }
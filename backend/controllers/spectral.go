@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+)
+
+// vibrationResponse adds the optional frequency axis to a reading
+type vibrationResponse struct {
+	models.VibrationData
+	FrequencyAxis []float64 `json:"frequency_axis,omitempty"`
+}
+
+// withFrequencyAxis attaches bin frequencies when the reading has spectral metadata
+func withFrequencyAxis(vibration models.VibrationData) vibrationResponse {
+	return vibrationResponse{
+		VibrationData: vibration,
+		FrequencyAxis: frequencyAxis(vibration.Spectral),
+	}
+}
+
+// frequencyAxis returns the frequency in Hz of every FFT bin
+func frequencyAxis(meta *models.SpectralMetadata) []float64 {
+	if meta == nil || meta.BinWidth <= 0 {
+		return nil
+	}
+	axis := make([]float64, meta.Lines)
+	for k := range axis {
+		axis[k] = float64(k) * meta.BinWidth
+	}
+	return axis
+}
+
+// applySpectralMetadata validates the spectra against the sensor's current
+// FMax and LOR and snapshots the frequency axis onto the reading, so later
+// sensor configuration changes do not change how stored bins are read.
+// Units, scaling, window and averaging may be supplied by the device.
+// Readings computed from a waveform keep the metadata of the computation.
+func applySpectralMetadata(sensor models.Sensor, vibration *models.VibrationData) error {
+	lines := len(vibration.FFTX)
+	if len(vibration.FFTY) != lines || len(vibration.FFTZ) != lines {
+		return &IngestError{http.StatusBadRequest, "FFT arrays must have the same length on all axes"}
+	}
+	if vibration.FromWaveform {
+		return nil
+	}
+	if sensor.LOR > 0 && lines != int(sensor.LOR) {
+		return &IngestError{http.StatusBadRequest, fmt.Sprintf("FFT length %d does not match the sensor's LOR of %.0f", lines, sensor.LOR)}
+	}
+
+	var meta models.SpectralMetadata
+	if vibration.Spectral != nil {
+		meta = *vibration.Spectral
+	}
+
+	if meta.Lines != 0 && meta.Lines != lines {
+		return &IngestError{http.StatusBadRequest, "spectral.lines does not match the FFT length"}
+	}
+	meta.Lines = lines
+
+	if sensor.FMax > 0 {
+		if meta.FMax != 0 && meta.FMax != sensor.FMax {
+			return &IngestError{http.StatusBadRequest, fmt.Sprintf("spectral.fmax %.0f does not match the sensor's fmax of %.0f", meta.FMax, sensor.FMax)}
+		}
+		meta.FMax = sensor.FMax
+	}
+
+	switch {
+	case meta.FMax > 0:
+		meta.BinWidth = meta.FMax / float64(lines)
+	case meta.BinWidth > 0:
+		meta.FMax = meta.BinWidth * float64(lines)
+	}
+
+	switch meta.Units {
+	case "":
+		meta.Units = models.UnitsG
+	case models.UnitsG, models.UnitsMMPerSec, models.UnitsMPerSec2:
+	default:
+		return &IngestError{http.StatusBadRequest, "spectral.units must be g, mm/s or m/s²"}
+	}
+
+	switch meta.Scaling {
+	case "":
+		meta.Scaling = models.ScalingPeak
+	case models.ScalingPeak, models.ScalingRMS, models.ScalingPeakToPeak:
+	default:
+		return &IngestError{http.StatusBadRequest, "spectral.scaling must be peak, rms or peak_to_peak"}
+	}
+
+	if meta.Averages < 0 {
+		return &IngestError{http.StatusBadRequest, "spectral.averages must not be negative"}
+	}
+
+	vibration.Spectral = &meta
+	return nil
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/realtime"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// Interval between keep-alive messages on idle streams
+	streamHeartbeat = 15 * time.Second
+
+	// How long a stream ticket can be used to open a stream
+	streamTicketTTL = 30 * time.Second
+)
+
+// vibrationSummary is the streamed form of a reading; spectra are left out
+// to keep the stream light and can be fetched with GET /vibrations/:id
+type vibrationSummary struct {
+	ID           string    `json:"id"`
+	SerialNumber string    `json:"serial_number"`
+	MeasuredAt   time.Time `json:"measured_at"`
+	ReceivedAt   time.Time `json:"received_at"`
+	RMSX         float64   `json:"rms_x"`
+	RMSY         float64   `json:"rms_y"`
+	RMSZ         float64   `json:"rms_z"`
+	PeakX        float64   `json:"peak_x"`
+	PeakY        float64   `json:"peak_y"`
+	PeakZ        float64   `json:"peak_z"`
+	Suppressed   bool      `json:"suppressed,omitempty"`
+
+	Anomaly *models.AnomalyScore `json:"anomaly,omitempty"`
+}
+
+// publishVibration pushes a newly stored reading to stream subscribers
+func publishVibration(sensor models.Sensor, vibration models.VibrationData) {
+	realtime.Publish(realtime.EventVibration, sensor.SerialNumber, sensor.Location, vibrationSummary{
+		ID:           vibration.ID.Hex(),
+		SerialNumber: vibration.SerialNumber,
+		MeasuredAt:   vibration.MeasuredAt,
+		ReceivedAt:   vibration.ReceivedAt,
+		RMSX:         vibration.RMSX,
+		RMSY:         vibration.RMSY,
+		RMSZ:         vibration.RMSZ,
+		PeakX:        vibration.PeakX,
+		PeakY:        vibration.PeakY,
+		PeakZ:        vibration.PeakZ,
+		Suppressed:   vibration.Suppressed,
+		Anomaly:      vibration.Anomaly,
+	})
+}
+
+// publishAlarm pushes an alarm state change to stream subscribers
+func publishAlarm(alarm models.Alarm) {
+	realtime.Publish(realtime.EventAlarm, alarm.SerialNumber, alarm.Location, alarm)
+}
+
+// streamableSerials returns the serial numbers of the sensors owned by the
+// user or, when the user belongs to an organization, by any of its members
+func streamableSerials(ctx context.Context, userID primitive.ObjectID) (map[string]bool, error) {
+	users := config.GetCollection("users")
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	owners := []interface{}{userID}
+	if user.Organization != "" {
+		var err error
+		owners, err = users.Distinct(ctx, "_id", bson.M{"organization": user.Organization})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serials, err := config.GetCollection("sensors").Distinct(ctx, "serial_number", bson.M{"user_id": bson.M{"$in": owners}})
+	if err != nil {
+		return nil, err
+	}
+	allowed := map[string]bool{}
+	for _, serial := range serials {
+		if s, ok := serial.(string); ok {
+			allowed[s] = true
+		}
+	}
+	return allowed, nil
+}
+
+// streamSubscription reads the filter and resume position shared by both
+// stream transports. Subscriptions are limited to the sensors the user may
+// see, as they are at subscribe time; without serial numbers the stream
+// carries all of them. On failure it writes the error response and ok is false.
+func streamSubscription(c *gin.Context) (filter realtime.Filter, resumeFrom uint64, ok bool) {
+	allowed, err := streamableSerials(context.Background(), c.MustGet("user_id").(primitive.ObjectID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sensors"})
+		return filter, 0, false
+	}
+
+	filter = realtime.Filter{Location: c.Query("location")}
+	if serials := c.Query("serial_number"); serials != "" {
+		filter.SerialNumbers = strings.Split(serials, ",")
+		for _, serial := range filter.SerialNumbers {
+			if !allowed[serial] {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed to stream sensor " + serial})
+				return filter, 0, false
+			}
+		}
+	} else {
+		for serial := range allowed {
+			filter.SerialNumbers = append(filter.SerialNumbers, serial)
+		}
+		if len(filter.SerialNumbers) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No sensors to stream"})
+			return filter, 0, false
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	resumeFrom, _ = strconv.ParseUint(lastEventID, 10, 64)
+
+	return filter, resumeFrom, true
+}
+
+// CreateStreamTicket issues a single-use ticket that opens one stream as the
+// authenticated user, for browsers that cannot send the Authorization header
+func CreateStreamTicket(c *gin.Context) {
+	id, err := generateSecureKey(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		return
+	}
+	ticket := models.StreamTicket{
+		ID:        id,
+		UserID:    c.MustGet("user_id").(primitive.ObjectID),
+		ExpiresAt: time.Now().Add(streamTicketTTL),
+	}
+	if _, err := config.GetCollection("stream_tickets").InsertOne(context.Background(), ticket); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		return
+	}
+	c.JSON(http.StatusCreated, ticket)
+}
+
+// checkStreamOrigin rejects WebSocket handshakes from pages of sites other
+// than the server's own and AllowedOrigins, since browsers do not hold
+// WebSockets to the same-origin policy. Clients that are not browsers send
+// no Origin.
+func checkStreamOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return nil
+	}
+	for _, allowed := range config.GetConfig().AllowedOrigins {
+		if origin == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// StreamEvents pushes readings and alarm changes as Server-Sent Events.
+// Clients resume with the Last-Event-ID header that EventSource sends on reconnect.
+func StreamEvents(c *gin.Context) {
+	filter, resumeFrom, ok := streamSubscription(c)
+	if !ok {
+		return
+	}
+	sub, backlog, complete := realtime.Subscribe(filter, resumeFrom)
+	defer realtime.Unsubscribe(sub)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	if !complete {
+		c.SSEvent("gap", gin.H{"last_event_id": resumeFrom})
+	}
+	for _, event := range backlog {
+		writeSSE(c, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects with its last event ID
+				c.SSEvent("overflow", gin.H{"message": "client too slow, reconnect to resume"})
+				return false
+			}
+			writeSSE(c, event)
+			return true
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func writeSSE(c *gin.Context, event realtime.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	io.WriteString(c.Writer, "id: "+strconv.FormatUint(event.ID, 10)+"\n")
+	io.WriteString(c.Writer, "event: "+event.Type+"\n")
+	io.WriteString(c.Writer, "data: "+string(data)+"\n\n")
+}
+
+// StreamWebSocket pushes readings and alarm changes over a WebSocket as JSON
+// messages. Clients resume with the last_event_id query parameter.
+func StreamWebSocket(c *gin.Context) {
+	filter, resumeFrom, ok := streamSubscription(c)
+	if !ok {
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: func(_ *websocket.Config, r *http.Request) error { return checkStreamOrigin(r) },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			sub, backlog, complete := realtime.Subscribe(filter, resumeFrom)
+			defer realtime.Unsubscribe(sub)
+
+			// Detect client disconnects; incoming messages are ignored
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			if !complete {
+				websocket.JSON.Send(ws, gin.H{"type": "gap", "last_event_id": resumeFrom})
+			}
+			for _, event := range backlog {
+				if websocket.JSON.Send(ws, event) != nil {
+					return
+				}
+			}
+
+			heartbeat := time.NewTicker(streamHeartbeat)
+			defer heartbeat.Stop()
+
+			for {
+				select {
+				case event, ok := <-sub.C:
+					if !ok {
+						websocket.JSON.Send(ws, gin.H{"type": "overflow", "message": "client too slow, reconnect to resume"})
+						return
+					}
+					ws.SetWriteDeadline(time.Now().Add(streamHeartbeat))
+					if websocket.JSON.Send(ws, event) != nil {
+						return
+					}
+				case <-heartbeat.C:
+					if websocket.JSON.Send(ws, gin.H{"type": "ping"}) != nil {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		},
+	}
+
+	server.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,400 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/analysis"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultLTTBPoints = 500
+	maxLTTBPoints     = 5000
+
+	// Most buckets returned by a trend, and most readings and rollups loaded
+	// to downsample one
+	maxTrendBuckets = 10000
+	maxLTTBReadings = 100000
+
+	// MongoDB major version that added $percentile
+	percentileServer = 7
+)
+
+var (
+	errTooManyBuckets  = fmt.Errorf("range spans more than %d buckets; use a larger interval or a shorter range", maxTrendBuckets)
+	errTooManyReadings = fmt.Errorf("range holds more than %d readings; use a shorter range or bucketed trends", maxLTTBReadings)
+)
+
+// Scalar metrics available for trends
+var trendMetrics = []string{"rms_x", "rms_y", "rms_z", "peak_x", "peak_y", "peak_z"}
+
+// Bucket sizes accepted by the aggregation, passed to $dateTrunc
+var trendIntervals = map[string]bool{"minute": true, "hour": true, "day": true}
+
+type trendBucket struct {
+	Time    time.Time                     `json:"time"`
+	Count   int64                         `json:"count"`
+	Metrics map[string]models.MetricStats `json:"metrics"`
+}
+
+// aggregateTrend buckets a sensor's readings by interval and computes
+// min/max/mean/p95 per metric in the database. p95 uses $percentile on
+// MongoDB 7.0 and later; older servers return the bucket's values and p95 is
+// computed here. A positive limit returns at most that many buckets.
+func aggregateTrend(ctx context.Context, serialNumber string, dateFilter bson.M, interval string, metrics []string, limit int) ([]trendBucket, error) {
+	match := bson.M{"serial_number": serialNumber}
+	if len(dateFilter) > 0 {
+		match["measured_at"] = dateFilter
+	}
+
+	version, err := config.ServerMajorVersion()
+	if err != nil {
+		return nil, err
+	}
+	percentile := version >= percentileServer
+
+	group := bson.M{
+		"_id":   bson.M{"$dateTrunc": bson.M{"date": "$measured_at", "unit": interval}},
+		"count": bson.M{"$sum": 1},
+	}
+	for _, metric := range metrics {
+		field := "$" + metric
+		group[metric+"_min"] = bson.M{"$min": field}
+		group[metric+"_max"] = bson.M{"$max": field}
+		group[metric+"_mean"] = bson.M{"$avg": field}
+		if percentile {
+			group[metric+"_p95"] = bson.M{"$percentile": bson.M{"input": field, "p": bson.A{0.95}, "method": "approximate"}}
+		} else {
+			group[metric+"_values"] = bson.M{"$push": field}
+		}
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$group": group},
+		{"$sort": bson.M{"_id": 1}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+
+	cursor, err := config.GetCollection("vibrations").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	buckets := []trendBucket{}
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		bucket := trendBucket{Metrics: map[string]models.MetricStats{}}
+		if t, ok := doc["_id"].(primitive.DateTime); ok {
+			bucket.Time = t.Time().UTC()
+		}
+		bucket.Count = int64(toFloat(doc["count"]))
+
+		for _, metric := range metrics {
+			stats := models.MetricStats{
+				Min:  toFloat(doc[metric+"_min"]),
+				Max:  toFloat(doc[metric+"_max"]),
+				Mean: toFloat(doc[metric+"_mean"]),
+			}
+			if p, ok := doc[metric+"_p95"].(bson.A); ok && len(p) > 0 {
+				stats.P95 = toFloat(p[0])
+			}
+			if values, ok := doc[metric+"_values"].(bson.A); ok {
+				samples := make([]float64, 0, len(values))
+				for _, value := range values {
+					if value != nil {
+						samples = append(samples, toFloat(value))
+					}
+				}
+				stats.P95 = analysis.Percentile(samples, 0.95)
+			}
+			bucket.Metrics[metric] = stats
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, cursor.Err()
+}
+
+// rollupTrend loads at most limit stored rollups for buckets whose raw
+// readings were removed by retention
+func rollupTrend(ctx context.Context, serialNumber string, dateFilter bson.M, interval string, metrics []string, limit int) ([]trendBucket, error) {
+	filter := bson.M{"serial_number": serialNumber, "interval": interval}
+	if len(dateFilter) > 0 {
+		filter["time"] = dateFilter
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: 1}}).
+		SetLimit(int64(limit))
+	cursor, err := config.GetCollection("vibration_rollups").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	buckets := []trendBucket{}
+	for cursor.Next(ctx) {
+		var rollup models.VibrationRollup
+		if err := cursor.Decode(&rollup); err != nil {
+			return nil, err
+		}
+		bucket := trendBucket{Time: rollup.Time.UTC(), Count: rollup.Count, Metrics: map[string]models.MetricStats{}}
+		for _, metric := range metrics {
+			bucket.Metrics[metric] = rollup.Metrics[metric]
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, cursor.Err()
+}
+
+// mergeTrend combines rollups with buckets computed from raw readings,
+// preferring the rollup where both exist. Rollups are only written for
+// periods that retention compacted, where the raw readings left are just
+// those kept for alarms.
+func mergeTrend(raw, rollups []trendBucket) []trendBucket {
+	seen := map[time.Time]bool{}
+	for _, bucket := range rollups {
+		seen[bucket.Time] = true
+	}
+
+	merged := append([]trendBucket{}, rollups...)
+	for _, bucket := range raw {
+		if !seen[bucket.Time] {
+			merged = append(merged, bucket)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+	return merged
+}
+
+// downsampleTrend loads metric values and reduces each series with LTTB.
+// Periods that retention compacted contribute the means of their hourly
+// rollups. It fails with errTooManyReadings rather than load more than
+// maxLTTBReadings values per series.
+func downsampleTrend(ctx context.Context, serialNumber string, dateFilter bson.M, metrics []string, points int) (map[string][]analysis.Point, error) {
+	rolledUntil, err := sensorRolledUntil(ctx, serialNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	series := map[string][]analysis.Point{}
+	loaded := 0
+	if !rolledUntil.IsZero() {
+		compacted, current := splitDateFilter(dateFilter, rolledUntil)
+		rollups, err := rollupTrend(ctx, serialNumber, compacted, models.RollupHour, metrics, maxLTTBReadings+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range rollups {
+			for _, metric := range metrics {
+				series[metric] = append(series[metric], analysis.Point{Time: bucket.Time, Value: bucket.Metrics[metric].Mean})
+			}
+		}
+		loaded = len(rollups)
+		dateFilter = current
+	}
+	if loaded > maxLTTBReadings {
+		return nil, errTooManyReadings
+	}
+
+	filter := bson.M{"serial_number": serialNumber}
+	if len(dateFilter) > 0 {
+		filter["measured_at"] = dateFilter
+	}
+
+	projection := bson.M{"measured_at": 1}
+	for _, metric := range metrics {
+		projection[metric] = 1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "measured_at", Value: 1}}).
+		SetLimit(int64(maxLTTBReadings - loaded + 1)).
+		SetProjection(projection)
+
+	cursor, err := config.GetCollection("vibrations").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if loaded++; loaded > maxLTTBReadings {
+			return nil, errTooManyReadings
+		}
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		t, _ := doc["measured_at"].(primitive.DateTime)
+		for _, metric := range metrics {
+			series[metric] = append(series[metric], analysis.Point{Time: t.Time().UTC(), Value: toFloat(doc[metric])})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, metric := range metrics {
+		series[metric] = analysis.LTTB(series[metric], points)
+		if series[metric] == nil {
+			series[metric] = []analysis.Point{}
+		}
+	}
+	return series, nil
+}
+
+// splitDateFilter splits a measured_at filter into the periods before and
+// from until
+func splitDateFilter(dateFilter bson.M, until time.Time) (before, from bson.M) {
+	before, from = bson.M{}, bson.M{}
+	for key, value := range dateFilter {
+		before[key] = value
+		from[key] = value
+	}
+	before["$lt"] = until
+	if start, ok := from["$gte"].(time.Time); !ok || start.Before(until) {
+		from["$gte"] = until
+	}
+	return before, from
+}
+
+// parseTrendMetrics reads a comma-separated metrics list, defaulting to all
+func parseTrendMetrics(value string) ([]string, error) {
+	if value == "" {
+		return trendMetrics, nil
+	}
+
+	var metrics []string
+	for _, metric := range strings.Split(value, ",") {
+		metric = strings.TrimSpace(metric)
+		known := false
+		for _, m := range trendMetrics {
+			if m == metric {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown metric %q", metric)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// GetVibrationTrend returns RMS and peak trends for a serial number, either
+// bucketed by interval or downsampled with LTTB (mode=lttb)
+func GetVibrationTrend(c *gin.Context) {
+	serialNumber := c.Query("serial_number")
+	if serialNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "serial_number is required"})
+		return
+	}
+
+	metrics, err := parseTrendMetrics(c.Query("metrics"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dateFilter, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("mode") == "lttb" {
+		points, err := strconv.Atoi(c.DefaultQuery("points", strconv.Itoa(defaultLTTBPoints)))
+		if err != nil || points < 3 || points > maxLTTBPoints {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("points must be between 3 and %d", maxLTTBPoints)})
+			return
+		}
+
+		series, err := downsampleTrend(context.Background(), serialNumber, dateFilter, metrics, points)
+		if err == errTooManyReadings {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"serial_number": serialNumber,
+			"mode":          "lttb",
+			"series":        series,
+		})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", "hour")
+	if !trendIntervals[interval] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be minute, hour or day"})
+		return
+	}
+
+	buckets, err := aggregateTrend(context.Background(), serialNumber, dateFilter, interval, metrics, maxTrendBuckets+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Older hourly and daily buckets may only survive as rollups
+	if interval != "minute" {
+		rollups, err := rollupTrend(context.Background(), serialNumber, dateFilter, interval, metrics, maxTrendBuckets+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		buckets = mergeTrend(buckets, rollups)
+	}
+	if len(buckets) > maxTrendBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errTooManyBuckets.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"serial_number": serialNumber,
+		"interval":      interval,
+		"buckets":       buckets,
+	})
+}
+
+// toFloat converts numeric BSON values to float64
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	}
+	return 0
+}
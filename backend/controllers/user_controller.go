@@ -7,11 +7,13 @@ import (
 	"time"
 
 	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/middleware"
 	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,12 +21,14 @@ func generateTokens(userID primitive.ObjectID) (string, string, time.Time, error
 	// Generate access token
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID.Hex(),
+		"type":    middleware.TokenTypeAccess,
 		"exp":     time.Now().Add(time.Hour * 24).Unix(), // 24 hours expiration
 	})
 
 	// Generate refresh token
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID.Hex(),
+		"type":    middleware.TokenTypeRefresh,
 		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days expiration
 	})
 
@@ -42,65 +46,110 @@ func generateTokens(userID primitive.ObjectID) (string, string, time.Time, error
 	return accessTokenString, refreshTokenString, time.Now().Add(time.Hour * 24), nil
 }
 
-func CreateUser(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// prepareUser validates a new user, hashes the password and issues tokens.
+// The ID is assigned up front so the tokens carry it.
+func prepareUser(ctx context.Context, user *models.User) error {
+	if user.Username == "" || user.Password == "" {
+		return &itemError{http.StatusBadRequest, "username and password are required"}
+	}
+
+	// Usernames identify users at login; the unique index on username
+	// catches users registered concurrently
+	var existingUser models.User
+	err := config.GetCollection("users").FindOne(ctx, bson.M{"username": user.Username}).Decode(&existingUser)
+	if err == nil {
+		return &itemError{http.StatusConflict, "username already exists"}
 	}
 
 	// Hash the password before storing
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
-		return
+		return &itemError{http.StatusInternalServerError, "Error hashing password"}
 	}
 	user.Password = string(hashedPassword)
+	user.ID = primitive.NewObjectID()
 
 	// Generate tokens
 	accessToken, refreshToken, tokenExpiry, err := generateTokens(user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating tokens"})
-		return
+		return &itemError{http.StatusInternalServerError, "Error generating tokens"}
 	}
 
 	user.Token = accessToken
 	user.RefreshToken = refreshToken
 	user.TokenExpiry = tokenExpiry
+	return nil
+}
+
+func CreateUser(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindJSON(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := prepareUser(context.Background(), &user); err != nil {
+		c.JSON(itemErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
 	collection := config.GetCollection("users")
-	result, err := collection.InsertOne(context.Background(), user)
+	_, err := collection.InsertOne(context.Background(), user)
+	if mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "username already exists"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user.ID = result.InsertedID.(primitive.ObjectID)
 	// Don't send password back
 	user.Password = ""
 	c.JSON(http.StatusCreated, user)
 }
 
 func GetUsers(c *gin.Context) {
-	var users []models.User
 	collection := config.GetCollection("users")
 
-	cursor, err := collection.Find(context.Background(), bson.M{})
+	// Users have no creation time, so they are paged by _id
+	page, err := parsePageRequest(c, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Without a cursor or limit, every user is returned as a bare array
+	if !page.paged {
+		users, err := findAll[models.User](context.Background(), collection, bson.M{}, page)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range users {
+			users[i].Password = ""
+		}
+		c.JSON(http.StatusOK, users)
+		return
+	}
+
+	users, pagination, err := findPage(context.Background(), collection, bson.M{}, page, nil, func(user models.User) pageCursor {
+		return pageCursor{ID: user.ID}
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	for cursor.Next(context.Background()) {
-		var user models.User
-		cursor.Decode(&user)
-		// Don't send passwords back
-		user.Password = ""
-		users = append(users, user)
+	// Don't send passwords back
+	for i := range users {
+		users[i].Password = ""
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, gin.H{
+		"data":       users,
+		"pagination": pagination,
+	})
 }
 
 func GetUser(c *gin.Context) {
@@ -280,26 +329,12 @@ func RefreshToken(c *gin.Context) {
 	}
 
 	// Verify refresh token
-	token, err := jwt.Parse(refreshData.RefreshToken, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.GetConfig().JWTSecret), nil
-	})
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
-		return
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
+	userID, ok := middleware.ParseToken(refreshData.RefreshToken, middleware.TokenTypeRefresh)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
 		return
 	}
 
-	userID, err := primitive.ObjectIDFromHex(claims["user_id"].(string))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
-		return
-	}
-
 	// Generate new tokens
 	accessToken, refreshToken, tokenExpiry, err := generateTokens(userID)
 	if err != nil {
@@ -334,6 +369,9 @@ func RefreshToken(c *gin.Context) {
 	})
 }
 
+// BatchRegisterUsers registers multiple users, validating each like
+// CreateUser and reporting each one's outcome. With ?atomic=true the users
+// are registered together or not at all.
 func BatchRegisterUsers(c *gin.Context) {
 	var users []models.User
 	if err := c.ShouldBindJSON(&users); err != nil {
@@ -341,58 +379,69 @@ func BatchRegisterUsers(c *gin.Context) {
 		return
 	}
 
-	collection := config.GetCollection("users")
-	var results []models.User
-	var errors []string
-
-	for _, user := range users {
-		// Hash the password before storing
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-		if err != nil {
-			errors = append(errors, "Error hashing password for user: "+user.Username)
-			continue
-		}
-		user.Password = string(hashedPassword)
+	report, err := newBatchReport(c, len(users))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		// Generate tokens
-		accessToken, refreshToken, tokenExpiry, err := generateTokens(user.ID)
-		if err != nil {
-			errors = append(errors, "Error generating tokens for user: "+user.Username)
-			continue
+	ctx := context.Background()
+	usernames := map[string]bool{}
+	var valid []int
+	for i := range users {
+		user := &users[i]
+		err := prepareUser(ctx, user)
+		if err == nil && usernames[user.Username] {
+			err = &itemError{http.StatusConflict, "username appears earlier in the batch"}
 		}
-
-		user.Token = accessToken
-		user.RefreshToken = refreshToken
-		user.TokenExpiry = tokenExpiry
-
-		result, err := collection.InsertOne(context.Background(), user)
+		usernames[user.Username] = true
 		if err != nil {
-			errors = append(errors, "Error creating user: "+user.Username)
+			report.fail(i, err)
 			continue
 		}
-
-		user.ID = result.InsertedID.(primitive.ObjectID)
-		// Don't send password back
-		user.Password = ""
-		results = append(results, user)
+		valid = append(valid, i)
 	}
 
-	response := gin.H{
-		"successful_registrations": len(results),
-		"failed_registrations":     len(errors),
-		"users":                    results,
+	// Don't send passwords back
+	created := func(i int) {
+		user := users[i]
+		user.Password = ""
+		report.succeed(i, batchCreated, user.ID, user)
 	}
 
-	if len(errors) > 0 {
-		response["errors"] = errors
+	collection := config.GetCollection("users")
+	switch {
+	case !report.atomic:
+		for _, i := range valid {
+			if _, err := collection.InsertOne(ctx, users[i]); err != nil {
+				report.fail(i, insertError(err, "username already exists", "Error creating user: "+users[i].Username))
+				continue
+			}
+			created(i)
+		}
+	case report.failed():
+		report.abort()
+	case len(valid) > 0:
+		documents := make([]interface{}, len(valid))
+		for k, i := range valid {
+			documents[k] = users[i]
+		}
+		err := withTransaction(ctx, func(sc mongo.SessionContext) error {
+			_, err := collection.InsertMany(sc, documents)
+			return err
+		})
+		if err != nil {
+			report.failInsert(valid, err, "username already exists", "Error creating users")
+			break
+		}
+		for _, i := range valid {
+			created(i)
+		}
 	}
 
-	if len(results) > 0 {
-		c.JSON(http.StatusCreated, response)
-	} else {
-		c.JSON(http.StatusBadRequest, response)
-	}
+	report.respond(c)
 }
+
 func Logout(c *gin.Context) {
 	// Get user ID from token
 	userID, exists := c.Get("user_id")
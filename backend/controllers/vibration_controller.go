@@ -2,8 +2,8 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
@@ -11,13 +11,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func CreateVibration(c *gin.Context) {
 	var vibration models.VibrationData
-	if err := c.ShouldBindJSON(&vibration); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := bindVibration(c, &vibration); err != nil {
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -28,41 +29,33 @@ func CreateVibration(c *gin.Context) {
 	}
 
 	// Check if sensor exists
-	sensorCollection := config.GetCollection("sensors")
-	var sensor models.Sensor
-	err := sensorCollection.FindOne(context.Background(), bson.M{"serial_number": vibration.SerialNumber}).Decode(&sensor)
+	sensor, err := sensorBySerialNumber(context.Background(), vibration.SerialNumber)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid serial number"})
 		return
 	}
 
-	// Validate FFT data
-	if len(vibration.FFTX) == 0 || len(vibration.FFTY) == 0 || len(vibration.FFTZ) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "FFT data is required for all axes"})
-		return
-	}
-
-	vibration.CreatedAt = time.Now()
+	ingestRequest(c, sensor, vibration)
+}
 
+func GetVibrations(c *gin.Context) {
 	collection := config.GetCollection("vibrations")
-	result, err := collection.InsertOne(context.Background(), vibration)
+
+	page, err := parsePageRequest(c, "measured_at")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Insert failed"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Offset pages report the total and page number, as they always have
+	if page.cursor == nil {
+		page.count = true
+	}
 
-	vibration.ID = result.InsertedID.(primitive.ObjectID)
-	c.JSON(http.StatusCreated, vibration)
-}
-
-func GetVibrations(c *gin.Context) {
-	var vibrations []models.VibrationData
-	collection := config.GetCollection("vibrations")
-
-	// Pagination parameters
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	skip := (page - 1) * limit
+	view, err := parseVibrationView(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	filter := bson.M{}
 
@@ -70,55 +63,59 @@ func GetVibrations(c *gin.Context) {
 		filter["serial_number"] = serialNumber
 	}
 
-	if startDate := c.Query("start_date"); startDate != "" {
-		if t, err := time.Parse(time.RFC3339, startDate); err == nil {
-			filter["created_at"] = bson.M{"$gte": t}
-		}
+	dateFilter, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-
-	if endDate := c.Query("end_date"); endDate != "" {
-		if t, err := time.Parse(time.RFC3339, endDate); err == nil {
-			if _, ok := filter["created_at"]; ok {
-				filter["created_at"].(bson.M)["$lte"] = t
-			} else {
-				filter["created_at"] = bson.M{"$lte": t}
-			}
-		}
+	if len(dateFilter) > 0 {
+		filter["measured_at"] = dateFilter
+	}
+	if c.Query("clock_skew") == "true" {
+		filter["clock_skew"] = bson.M{"$exists": true}
 	}
 
-	// Add pagination options
-	opts := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit)).
-		SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var projection bson.M
+	if view != nil {
+		projection = view.projection
+	}
 
-	cursor, err := collection.Find(context.Background(), filter, opts)
+	vibrations, pagination, err := findPage(context.Background(), collection, filter, page, projection, func(vib models.VibrationData) pageCursor {
+		return pageCursor{Time: vib.MeasuredAt, ID: vib.ID}
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer cursor.Close(context.Background())
+	if page.cursor == nil {
+		pagination["page"] = page.number
+	}
 
-	for cursor.Next(context.Background()) {
-		var vib models.VibrationData
-		cursor.Decode(&vib)
-		vibrations = append(vibrations, vib)
+	// Offloaded arrays are loaded back, so every reading of a page has the
+	// same shape
+	if view.includesSpectra() {
+		if err := hydrateSpectraAll(context.Background(), vibrations); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	// Get total count for pagination
-	total, err := collection.CountDocuments(context.Background(), filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	var data interface{} = vibrations
+	withAxis := c.Query("frequency_axis") == "true"
+	if view != nil || withAxis {
+		rendered := make([]interface{}, len(vibrations))
+		for i, vib := range vibrations {
+			if rendered[i], err = view.render(vib, withAxis); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		data = rendered
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data": vibrations,
-		"pagination": gin.H{
-			"total": total,
-			"page":  page,
-			"limit": limit,
-		},
+		"data":       data,
+		"pagination": pagination,
 	})
 }
 
@@ -130,15 +127,39 @@ func GetVibration(c *gin.Context) {
 		return
 	}
 
+	view, err := parseVibrationView(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := options.FindOne()
+	if view != nil {
+		opts.SetProjection(view.projection)
+	}
+
 	var vib models.VibrationData
 	collection := config.GetCollection("vibrations")
-	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&vib)
+	err = collection.FindOne(context.Background(), bson.M{"_id": objectID}, opts).Decode(&vib)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Vibration data not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, vib)
+	if view.includesSpectra() {
+		if err := hydrateSpectra(context.Background(), &vib); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	response, err := view.render(vib, c.Query("frequency_axis") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func UpdateVibration(c *gin.Context) {
@@ -198,130 +219,126 @@ func DeleteVibration(c *gin.Context) {
 	}
 
 	collection := config.GetCollection("vibrations")
-	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objectID})
+	var deleted models.VibrationData
+	opts := options.FindOneAndDelete().SetProjection(bson.M{"spectra_blob": 1})
+	err = collection.FindOneAndDelete(context.Background(), bson.M{"_id": objectID}, opts).Decode(&deleted)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vibration data not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Vibration data not found"})
-		return
-	}
+	deleteSpectraBlob(context.Background(), deleted.SpectraBlob)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Vibration data deleted"})
 }
 
+// BatchRegisterVibrations stores readings of several sensors, validating each
+// like a single reading and reporting each one's outcome. Without ?atomic=true
+// valid readings are stored even when others fail.
 func BatchRegisterVibrations(c *gin.Context) {
 	var vibrations []models.VibrationData
-	if err := c.ShouldBindJSON(&vibrations); err != nil {
+	if err := decompressRequest(c); err != nil {
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := bindError(c.ShouldBindJSON(&vibrations)); err != nil {
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := newBatchReport(c, len(vibrations))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// MongoDB does not allow writes to time-series collections in transactions
+	if report.atomic && config.GetConfig().VibrationsTimeSeries {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Atomic batches are not supported for time-series vibrations"})
+		return
+	}
+
+	// Items without their own message ID derive one from the request's
+	// Idempotency-Key and their position
+	batchKey := c.GetHeader(IdempotencyHeader)
+	messageIDs := map[string]bool{}
 
 	// Validate each vibration entry
-	for _, vibration := range vibrations {
-		// Validate serial number
-		if vibration.SerialNumber == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Serial number is required for all entries"})
-			return
+	ctx := context.Background()
+	receivedAt := time.Now()
+	sensors := newSensorLookup(nil)
+	pending := make([]pendingReading, 0, len(vibrations))
+	for i := range vibrations {
+		vibration := vibrations[i]
+		if vibration.MessageID == "" && batchKey != "" {
+			vibration.MessageID = fmt.Sprintf("%s/%d", batchKey, i)
 		}
 
-		// Check if sensor exists
-		sensorCollection := config.GetCollection("sensors")
-		var sensor models.Sensor
-		err := sensorCollection.FindOne(context.Background(), bson.M{"serial_number": vibration.SerialNumber}).Decode(&sensor)
+		sensor, err := sensors.find(vibration.SerialNumber)
+		if err == nil {
+			err = prepareVibration(ctx, sensor, &vibration, receivedAt)
+		}
+		if err == nil && vibration.MessageID != "" {
+			messageID := vibration.SerialNumber + "\x00" + vibration.MessageID
+			if messageIDs[messageID] {
+				err = &IngestError{http.StatusBadRequest, "Duplicate message ID in batch: " + vibration.MessageID}
+			}
+			messageIDs[messageID] = true
+		}
+		if err == nil {
+			err = limitIngest(ctx, sensor, 1)
+		}
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid serial number: " + vibration.SerialNumber})
-			return
+			report.fail(i, err)
+			continue
 		}
+		pending = append(pending, pendingReading{index: i, sensor: sensor, vibration: vibration})
+	}
 
-		// Validate FFT data
-		if len(vibration.FFTX) == 0 || len(vibration.FFTY) == 0 || len(vibration.FFTZ) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "FFT data is required for all axes"})
+	done := func(item *pendingReading, status string, err error) {
+		if err != nil {
+			report.fail(item.index, err)
 			return
 		}
-
-		// Set created_at if not provided
-		if vibration.CreatedAt.IsZero() {
-			vibration.CreatedAt = time.Now()
+		report.succeed(item.index, status, item.vibration.ID, item.vibration)
+		if status == batchCreated {
+			processVibration(item.sensor, item.vibration)
 		}
 	}
 
-	// Prepare documents for bulk insert
-	documents := make([]interface{}, len(vibrations))
-	for i, vibration := range vibrations {
-		documents[i] = vibration
-	}
-
-	collection := config.GetCollection("vibrations")
-	result, err := collection.InsertMany(context.Background(), documents)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Batch insert failed"})
-		return
-	}
-
-	// Update IDs in the response
-	for i, id := range result.InsertedIDs {
-		vibrations[i].ID = id.(primitive.ObjectID)
+	switch {
+	case !report.atomic:
+		insertReadings(ctx, pending, done)
+	case report.failed():
+		report.abort()
+	default:
+		if err := insertReadingsAtomic(ctx, pending, done); err != nil {
+			report.abort()
+		}
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Successfully registered batch of vibration data",
-		"count":   len(vibrations),
-		"data":    vibrations,
-	})
+	report.respond(c)
 }
 
 // CreateVibrationWithAPIKey handles vibration data submission with API key authentication
 func CreateVibrationWithAPIKey(c *gin.Context) {
-	apiKey := c.Param("apikey")
-	if apiKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "API key is required"})
-		return
-	}
-
-	// Find sensor by API key
-	sensorCollection := config.GetCollection("sensors")
-	var sensor models.Sensor
-	err := sensorCollection.FindOne(context.Background(), bson.M{"api_key": apiKey}).Decode(&sensor)
+	sensor, err := authenticateAPIKey(context.Background(), c.Param("apikey"))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
+	limitPayload(c, sensor)
 	var vibrationData models.VibrationData
-	if err := c.ShouldBindJSON(&vibrationData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Validate that the provided serial number matches the sensor's serial number
-	if vibrationData.SerialNumber != "" && vibrationData.SerialNumber != sensor.SerialNumber {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Serial number does not match the authenticated sensor"})
-		return
-	}
-
-	// Set the serial number from the authenticated sensor
-	vibrationData.SerialNumber = sensor.SerialNumber
-	vibrationData.CreatedAt = time.Now()
-
-	// Validate required fields
-	if len(vibrationData.FFTX) == 0 || len(vibrationData.FFTY) == 0 || len(vibrationData.FFTZ) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "FFT data is required for all axes"})
-		return
-	}
-
-	// Insert the vibration data
-	collection := config.GetCollection("vibrations")
-	result, err := collection.InsertOne(context.Background(), vibrationData)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store vibration data"})
+	if err := bindVibration(c, &vibrationData); err != nil {
+		if quotaErr := checkPayloadQuota(c, sensor); quotaErr != nil {
+			err = quotaErr
+		}
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	// Set the ID from the inserted document
-	vibrationData.ID = result.InsertedID.(primitive.ObjectID)
-
-	c.JSON(http.StatusCreated, vibrationData)
+	ingestRequest(c, sensor, vibrationData)
 }
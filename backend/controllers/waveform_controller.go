@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/analysis"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Most samples accepted per axis waveform
+const maxWaveformSamples = 1 << 18
+
+// waveformRequest carries raw acceleration samples in g for each axis
+type waveformRequest struct {
+	SerialNumber string    `json:"serial_number"`
+	MessageID    string    `json:"message_id"`
+	MeasuredAt   time.Time `json:"measured_at"`
+	SampleRate   float64   `json:"sample_rate" binding:"required"`
+	Window       string    `json:"window"`
+	Waveforms    []struct {
+		Axis    string    `json:"axis"`
+		Samples []float64 `json:"samples"`
+	} `json:"waveforms" binding:"required"`
+}
+
+// axisResult holds the values derived from one axis waveform
+type axisResult struct {
+	samples  []float64
+	fft      []float64
+	velocity []float64
+	rms      float64
+	peak     float64
+	crest    float64
+}
+
+// vibrationFromWaveform computes spectra, RMS, peak, crest factor and velocity
+// spectra from raw waveforms. When the sensor has FMax and LOR configured the
+// spectra are resampled onto LOR lines up to FMax so they match readings
+// computed on the device.
+func vibrationFromWaveform(sensor models.Sensor, request waveformRequest) (models.VibrationData, error) {
+	var vibration models.VibrationData
+
+	window := strings.ToLower(request.Window)
+	if window == "" {
+		window = analysis.WindowHann
+	}
+	if sensor.FMax > 0 && request.SampleRate/2 < sensor.FMax {
+		return vibration, fmt.Errorf("sample rate %.0f Hz cannot resolve the sensor's fmax of %.0f Hz", request.SampleRate, sensor.FMax)
+	}
+
+	spectral := &models.SpectralMetadata{
+		Units:    models.UnitsG,
+		Scaling:  models.ScalingPeak,
+		Window:   window,
+		Averages: 1,
+	}
+
+	axes := map[string]*axisResult{}
+	for _, waveform := range request.Waveforms {
+		axis := strings.ToLower(waveform.Axis)
+		if axis != "x" && axis != "y" && axis != "z" {
+			return vibration, fmt.Errorf("unknown axis %q", waveform.Axis)
+		}
+
+		if len(waveform.Samples) > maxWaveformSamples {
+			return vibration, fmt.Errorf("axis %s: waveform exceeds %d samples", axis, maxWaveformSamples)
+		}
+
+		spectrum, err := analysis.AmplitudeSpectrum(waveform.Samples, request.SampleRate, window)
+		if err != nil {
+			return vibration, fmt.Errorf("axis %s: %v", axis, err)
+		}
+		if sensor.FMax > 0 && sensor.LOR > 0 {
+			spectrum = spectrum.Resample(sensor.FMax/sensor.LOR, int(sensor.LOR))
+		}
+		spectral.BinWidth = spectrum.BinWidth
+		spectral.Lines = len(spectrum.Amplitudes)
+		spectral.FMax = spectrum.BinWidth * float64(spectral.Lines)
+
+		axes[axis] = &axisResult{
+			samples:  waveform.Samples,
+			fft:      spectrum.Amplitudes,
+			velocity: analysis.VelocitySpectrum(spectrum).Amplitudes,
+			rms:      analysis.RMS(waveform.Samples),
+			peak:     analysis.Peak(waveform.Samples),
+			crest:    analysis.CrestFactor(waveform.Samples),
+		}
+	}
+
+	x, y, z := axes["x"], axes["y"], axes["z"]
+	if x == nil || y == nil || z == nil {
+		return vibration, fmt.Errorf("waveforms are required for all axes")
+	}
+
+	vibration = models.VibrationData{
+		SerialNumber: request.SerialNumber,
+		FromWaveform: true,
+		FFTX:         x.fft,
+		FFTY:         y.fft,
+		FFTZ:         z.fft,
+		Spectral:     spectral,
+		RMSX:         x.rms,
+		RMSY:         y.rms,
+		RMSZ:         z.rms,
+		PeakX:        x.peak,
+		PeakY:        y.peak,
+		PeakZ:        z.peak,
+		CrestX:       x.crest,
+		CrestY:       y.crest,
+		CrestZ:       z.crest,
+		VelocityX:    x.velocity,
+		VelocityY:    y.velocity,
+		VelocityZ:    z.velocity,
+		Waveform: &models.Waveform{
+			SampleRate: request.SampleRate,
+			Window:     window,
+			X:          x.samples,
+			Y:          y.samples,
+			Z:          z.samples,
+		},
+	}
+	return vibration, nil
+}
+
+// bindWaveform decodes a (possibly compressed) waveform request body
+func bindWaveform(c *gin.Context, request *waveformRequest) error {
+	if err := decompressRequest(c); err != nil {
+		return err
+	}
+	return bindError(c.ShouldBindJSON(request))
+}
+
+// CreateVibrationFromWaveform computes and stores a reading from raw waveforms,
+// identifying the sensor by serial number
+func CreateVibrationFromWaveform(c *gin.Context) {
+	var request waveformRequest
+	if err := bindWaveform(c, &request); err != nil {
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.SerialNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Serial number is required"})
+		return
+	}
+
+	sensor, err := sensorBySerialNumber(context.Background(), request.SerialNumber)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid serial number"})
+		return
+	}
+
+	storeWaveformReading(c, sensor, request)
+}
+
+// CreateVibrationFromWaveformWithAPIKey computes and stores a reading from raw
+// waveforms, authenticating the sensor by API key
+func CreateVibrationFromWaveformWithAPIKey(c *gin.Context) {
+	sensor, err := authenticateAPIKey(context.Background(), c.Param("apikey"))
+	if err != nil {
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	limitPayload(c, sensor)
+	var request waveformRequest
+	if err := bindWaveform(c, &request); err != nil {
+		if quotaErr := checkPayloadQuota(c, sensor); quotaErr != nil {
+			err = quotaErr
+		}
+		c.JSON(ingestErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	storeWaveformReading(c, sensor, request)
+}
+
+func storeWaveformReading(c *gin.Context, sensor models.Sensor, request waveformRequest) {
+	vibration, err := vibrationFromWaveform(sensor, request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	vibration.MessageID = idempotencyKey(c, request.MessageID)
+	vibration.MeasuredAt = request.MeasuredAt
+	ingestRequest(c, sensor, vibration)
+}
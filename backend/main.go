@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/blobstore"
 	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
 	"github.com/ThirawatEu/vibration-sensor-gas-pipe/controllers"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/middleware"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/mqttbridge"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/notifications"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,12 +26,74 @@ func main() {
 		log.Fatal("Failed to connect to MongoDB:", err)
 	}
 
+	// Create vibrations as a time-series collection when enabled
+	if err := config.EnsureVibrationsCollection(); err != nil {
+		log.Fatal("Failed to create vibrations collection:", err)
+	}
+
+	// Give readings stored before device timestamps were kept a measured_at,
+	// which list, trend and retention queries filter on
+	go func() {
+		updated, err := config.BackfillTimestamps(context.Background(), "vibrations", 1000)
+		if err != nil {
+			log.Printf("Failed to backfill reading timestamps, run cmd/migrate-timeseries -backfill-timestamps: %v", err)
+		} else if updated > 0 {
+			log.Printf("Backfilled timestamps on %d readings", updated)
+		}
+	}()
+
+	// Create indexes used by list and ingest queries
+	if err := config.EnsureIndexes(); err != nil {
+		log.Fatal("Failed to create indexes:", err)
+	}
+
 	// Initialize default warnings
 	err = controllers.InitializeWarnings()
 	if err != nil {
 		log.Fatal("Failed to initialize warnings:", err)
 	}
 
+	// Initialize notification providers
+	notifications.Setup(config.GetConfig())
+
+	// Initialize object storage for large spectra
+	if err := blobstore.Setup(config.GetConfig()); err != nil {
+		log.Fatal("Failed to initialize blob store:", err)
+	}
+
+	// Initialize ingest rate limiting
+	if err := ratelimit.Setup(config.GetConfig()); err != nil {
+		log.Fatal("Failed to initialize rate limiter:", err)
+	}
+
+	// Store readings in the background when async ingest is enabled
+	if err := controllers.StartIngestPipeline(config.GetConfig()); err != nil {
+		log.Fatal("Failed to start ingest pipeline:", err)
+	}
+
+	// Keep cached sensors in step with changes made by any instance
+	if config.GetConfig().SensorCacheSize > 0 {
+		go controllers.WatchSensorChanges(context.Background())
+	}
+
+	// Escalate unacknowledged alarms in the background
+	if config.GetConfig().EscalationInterval > 0 {
+		go controllers.StartEscalationScheduler(config.GetConfig().EscalationInterval)
+	}
+
+	// Compact old vibration data according to retention policies
+	if config.GetConfig().RetentionInterval > 0 {
+		go controllers.StartRetentionScheduler(config.GetConfig().RetentionInterval)
+	}
+
+	// Start MQTT ingestion when a broker is configured
+	var bridge *mqttbridge.Bridge
+	if config.GetConfig().MQTTBrokerURL != "" {
+		if bridge, err = mqttbridge.Start(config.GetConfig()); err != nil {
+			log.Fatal("Failed to start MQTT bridge:", err)
+		}
+	}
+
 	// Initialize Gin router
 	r := gin.Default()
 
@@ -35,6 +106,18 @@ func main() {
 	r.GET("/sensors/:id", controllers.GetSensor)                        // Get specific sensor
 	r.PUT("/sensors/:id", controllers.UpdateSensor)                     // Update sensor
 	r.DELETE("/sensors/:id", controllers.DeleteSensor)                  // Delete sensor
+	r.GET("/sensors/:id/usage", controllers.GetSensorUsage)             // Ingest quota and rate limit usage
+
+	// Sensor Analytics Routes
+	r.GET("/sensors/:id/analytics/spectral", controllers.GetSpectralAnalytics) // Band energy and fault frequency trends
+	r.GET("/sensors/:id/forecast", controllers.GetSensorForecast)              // Projected alarm threshold crossing
+	r.GET("/forecasts/urgent", controllers.GetUrgentForecasts)                 // Sensors closest to their alarm threshold
+
+	// Baseline Routes
+	// A baseline learned from a healthy period scores each new reading for anomalies
+	r.POST("/sensors/:id/baseline", controllers.SetBaseline)      // Learn baseline from a period
+	r.GET("/sensors/:id/baseline", controllers.GetBaseline)       // Get baseline
+	r.DELETE("/sensors/:id/baseline", controllers.DeleteBaseline) // Reset baseline
 
 	// User Management Routes
 	// Handles user registration, authentication, and management
@@ -45,6 +128,9 @@ func main() {
 	r.PUT("/users/:id", controllers.UpdateUser)                     // Update user
 	r.DELETE("/users/:id", controllers.DeleteUser)                  // Delete user
 
+	r.GET("/users/:id/notifications", controllers.GetNotificationSettings)    // Get phone and notification preferences
+	r.PUT("/users/:id/notifications", controllers.UpdateNotificationSettings) // Update phone and notification preferences
+
 	r.POST("/login", controllers.Login)                // User login
 	r.POST("/refresh-token", controllers.RefreshToken) // Refresh access token
 
@@ -53,15 +139,79 @@ func main() {
 	r.GET("/warnings", controllers.GetWarnings)    // Get all warnings
 	r.GET("/warnings/:id", controllers.GetWarning) // Get specific warning
 
+	// Alarm Routes
+	// Alarms are raised from incoming readings and escalated until acknowledged
+	r.GET("/alarms", controllers.GetAlarms)                         // Get alarms
+	r.GET("/alarms/:id", controllers.GetAlarm)                      // Get alarm with escalation history
+	r.POST("/alarms/:id/acknowledge", controllers.AcknowledgeAlarm) // Acknowledge alarm and stop escalation
+	r.POST("/alarms/:id/resolve", controllers.ResolveAlarm)         // Resolve alarm
+
+	// Maintenance Window Routes
+	// Readings are still stored during a window but raise no alarms
+	r.POST("/maintenance-windows", controllers.CreateMaintenanceWindow)       // Schedule maintenance window
+	r.POST("/maintenance-windows/silence", controllers.SilenceSensors)        // Silence sensors for N minutes
+	r.GET("/maintenance-windows", controllers.GetMaintenanceWindows)          // Get maintenance windows
+	r.GET("/maintenance-windows/:id", controllers.GetMaintenanceWindow)       // Get specific window
+	r.PUT("/maintenance-windows/:id", controllers.UpdateMaintenanceWindow)    // Update window
+	r.DELETE("/maintenance-windows/:id", controllers.DeleteMaintenanceWindow) // Delete window
+
+	// On-call Schedule Routes
+	r.POST("/oncall-schedules", controllers.CreateOnCallSchedule)            // Create rotation
+	r.GET("/oncall-schedules", controllers.GetOnCallSchedules)               // Get rotations
+	r.GET("/oncall-schedules/:id", controllers.GetOnCallSchedule)            // Get specific rotation
+	r.GET("/oncall-schedules/:id/current", controllers.GetCurrentOnCall)     // Get who is on call
+	r.PUT("/oncall-schedules/:id", controllers.UpdateOnCallSchedule)         // Update rotation
+	r.POST("/oncall-schedules/:id/overrides", controllers.AddOnCallOverride) // Add override
+	r.DELETE("/oncall-schedules/:id", controllers.DeleteOnCallSchedule)      // Delete rotation
+
+	// Escalation Policy Routes
+	r.POST("/escalation-policies", controllers.CreateEscalationPolicy)       // Create policy
+	r.GET("/escalation-policies", controllers.GetEscalationPolicies)         // Get policies
+	r.GET("/escalation-policies/:id", controllers.GetEscalationPolicy)       // Get specific policy
+	r.PUT("/escalation-policies/:id", controllers.UpdateEscalationPolicy)    // Update policy
+	r.DELETE("/escalation-policies/:id", controllers.DeleteEscalationPolicy) // Delete policy
+
+	// Retention Policy Routes
+	// Raw readings past the raw tier are rolled up hourly and daily, then deleted
+	r.POST("/retention-policies", controllers.CreateRetentionPolicy)       // Create policy
+	r.GET("/retention-policies", controllers.GetRetentionPolicies)         // Get policies
+	r.POST("/retention-policies/run", controllers.RunRetention)            // Apply policies now
+	r.GET("/retention-policies/:id", controllers.GetRetentionPolicy)       // Get specific policy
+	r.PUT("/retention-policies/:id", controllers.UpdateRetentionPolicy)    // Update policy
+	r.DELETE("/retention-policies/:id", controllers.DeleteRetentionPolicy) // Delete policy
+
 	// Vibration Data Routes
 	r.POST("/vibrations", controllers.CreateVibration)
 	r.POST("/vibrations/batch-register", controllers.BatchRegisterVibrations)
 	r.GET("/vibrations", controllers.GetVibrations)
+	r.GET("/vibrations/trend", controllers.GetVibrationTrend) // RMS and peak trends, bucketed or LTTB-downsampled
 	r.GET("/vibrations/:id", controllers.GetVibration)
+	r.GET("/vibrations/:id/spectrum/:axis", controllers.GetVibrationSpectrum) // Single-axis spectrum on demand
+	r.GET("/vibrations/:id/compare", controllers.CompareVibration)            // Spectrum diff against another reading or the baseline
 	r.PUT("/vibrations/:id", controllers.UpdateVibration)
 	r.DELETE("/vibrations/:id", controllers.DeleteVibration)
 	r.POST("/:apikey/vibrations", controllers.CreateVibrationWithAPIKey)
 
+	// Raw waveform ingestion; spectra and statistics are computed server-side
+	r.POST("/vibrations/waveform", controllers.CreateVibrationFromWaveform)
+	r.POST("/:apikey/vibrations/waveform", controllers.CreateVibrationFromWaveformWithAPIKey)
+
+	// Store-and-forward Routes
+	// Gateways upload buffered readings as NDJSON and get a per-line report back
+	r.POST("/vibrations/backfill", middleware.RequireAuth(), controllers.BackfillVibrations) // Readings of the user's sensors
+	r.POST("/:apikey/vibrations/backfill", controllers.BackfillVibrationsWithAPIKey)         // Readings of one sensor
+
+	// Ingest mode, queue depth, drops and store latency
+	r.GET("/ingest/metrics", controllers.GetIngestMetrics)
+
+	// Streaming Routes
+	// Push new readings and alarm changes; filter with serial_number and location
+	// Browsers authenticate with a single-use ticket in the ticket query parameter
+	stream := r.Group("/stream")
+	stream.POST("/ticket", middleware.RequireAuth(), controllers.CreateStreamTicket) // Single-use ticket for browsers
+	stream.GET("/events", middleware.RequireStreamAuth(), controllers.StreamEvents)  // Server-Sent Events
+	stream.GET("/ws", middleware.RequireStreamAuth(), controllers.StreamWebSocket)   // WebSocket
+
 	// Health Check Routes
 	// Basic endpoints to check server status
 	r.GET("/", func(c *gin.Context) {
@@ -78,5 +228,28 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	r.Run("0.0.0.0:" + port)
+	server := &http.Server{Addr: "0.0.0.0:" + port, Handler: r}
+
+	// Stop taking requests on SIGINT or SIGTERM, then store queued readings
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server error:", err)
+		}
+	}()
+	<-stop.Done()
+
+	log.Println("Shutting down")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), config.GetConfig().ShutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Failed to finish open requests:", err)
+	}
+	if bridge != nil {
+		bridge.Stop()
+	}
+	if err := controllers.StopIngestPipeline(ctx); err != nil {
+		log.Println("Failed to drain ingest queue:", err)
+	}
 }
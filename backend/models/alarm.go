@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Alarm lifecycle states
+const (
+	AlarmStatusOpen         = "open"
+	AlarmStatusAcknowledged = "acknowledged"
+	AlarmStatusResolved     = "resolved"
+)
+
+type Alarm struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SensorID     primitive.ObjectID `json:"sensor_id" bson:"sensor_id"`
+	SerialNumber string             `json:"serial_number" bson:"serial_number"`
+	Location     string             `json:"location" bson:"location"`
+	Organization string             `json:"organization" bson:"organization"`
+	VibrationID  primitive.ObjectID `json:"vibration_id" bson:"vibration_id"` // Reading that raised the alarm
+	Level        int                `json:"level" bson:"level"`
+	Status       string             `json:"status" bson:"status"`
+	TriggeredAt  time.Time          `json:"triggered_at" bson:"triggered_at"`
+
+	AcknowledgedAt *time.Time          `json:"acknowledged_at,omitempty" bson:"acknowledged_at,omitempty"`
+	AcknowledgedBy *primitive.ObjectID `json:"acknowledged_by,omitempty" bson:"acknowledged_by,omitempty"`
+	ResolvedAt     *time.Time          `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+
+	// Escalation state
+	EscalationPolicyID *primitive.ObjectID `json:"escalation_policy_id,omitempty" bson:"escalation_policy_id,omitempty"`
+	EscalationStep     int                 `json:"escalation_step" bson:"escalation_step"` // Index of the next policy step
+	NextEscalationAt   *time.Time          `json:"next_escalation_at,omitempty" bson:"next_escalation_at,omitempty"`
+	Escalations        []EscalationEvent   `json:"escalations" bson:"escalations"`
+}
+
+// EscalationEvent records who was notified for an alarm and when.
+// Step 0 is the initial notification of the sensor owner.
+type EscalationEvent struct {
+	Step       int                  `json:"step" bson:"step"`
+	UserIDs    []primitive.ObjectID `json:"user_ids" bson:"user_ids"`
+	Channels   []string             `json:"channels" bson:"channels"`
+	NotifiedAt time.Time            `json:"notified_at" bson:"notified_at"`
+	Error      string               `json:"error,omitempty" bson:"error,omitempty"` // Why nobody was notified
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Baseline describes the healthy behaviour of a sensor, learned from the
+// readings in [Start, End]. There is at most one baseline per sensor.
+type Baseline struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SensorID     primitive.ObjectID `json:"sensor_id" bson:"sensor_id"`
+	SerialNumber string             `json:"serial_number" bson:"serial_number"`
+	Start        time.Time          `json:"start" bson:"start"`
+	End          time.Time          `json:"end" bson:"end"`
+	Samples      int                `json:"samples" bson:"samples"`
+
+	// Mean and spread of the scalar metrics, keyed by field name (rms_x, peak_z, ...)
+	Metrics map[string]MetricBaseline `json:"metrics" bson:"metrics"`
+
+	// Per-bin spectral envelope keyed by axis (x, y, z), on the frequency
+	// axis described by Spectral
+	Envelope map[string]SpectralEnvelope `json:"envelope" bson:"envelope"`
+	Spectral *SpectralMetadata           `json:"spectral,omitempty" bson:"spectral,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+type MetricBaseline struct {
+	Mean   float64 `json:"mean" bson:"mean"`
+	StdDev float64 `json:"std_dev" bson:"std_dev"`
+}
+
+type SpectralEnvelope struct {
+	Mean   []float64 `json:"mean" bson:"mean"`
+	StdDev []float64 `json:"std_dev" bson:"std_dev"`
+}
+
+// AnomalyScore rates a reading against its sensor's baseline. Score is the
+// largest absolute z-score of the scalar metrics; EnvelopeExceedances counts
+// spectrum bins above the envelope mean plus three standard deviations.
+type AnomalyScore struct {
+	Score               float64            `json:"score" bson:"score"`
+	Metric              string             `json:"metric" bson:"metric"` // Metric with the largest z-score
+	EnvelopeExceedances int                `json:"envelope_exceedances" bson:"envelope_exceedances"`
+	BaselineID          primitive.ObjectID `json:"baseline_id" bson:"baseline_id"`
+}
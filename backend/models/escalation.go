@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Escalation target types
+const (
+	EscalationTargetUser     = "user"
+	EscalationTargetSchedule = "schedule"
+)
+
+// EscalationPolicy lists who to notify, in order, while an alarm stays unacknowledged
+type EscalationPolicy struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Organization string             `json:"organization" bson:"organization"`
+	Name         string             `json:"name" bson:"name"`
+	MinLevel     int                `json:"min_level" bson:"min_level"` // Lowest alarm level the policy applies to
+	Steps        []EscalationStep   `json:"steps" bson:"steps"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// EscalationStep notifies its targets DelayMinutes after the previous step
+// (or after the alarm was raised for the first step)
+type EscalationStep struct {
+	DelayMinutes int                `json:"delay_minutes" bson:"delay_minutes"`
+	TargetType   string             `json:"target_type" bson:"target_type"`
+	TargetID     primitive.ObjectID `json:"target_id" bson:"target_id"`
+}
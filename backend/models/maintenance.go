@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Maintenance window recurrence options
+const (
+	RecurrenceNone   = ""
+	RecurrenceDaily  = "daily"
+	RecurrenceWeekly = "weekly"
+)
+
+// MaintenanceWindow suppresses alarms for matching sensors between Start and End.
+// A window matches sensors listed in SerialNumbers and sensors at Location.
+type MaintenanceWindow struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name            string             `json:"name" bson:"name"`
+	Reason          string             `json:"reason" bson:"reason"`
+	SerialNumbers   []string           `json:"serial_numbers" bson:"serial_numbers"`
+	Location        string             `json:"location,omitempty" bson:"location,omitempty"`
+	Start           time.Time          `json:"start" bson:"start"`
+	End             time.Time          `json:"end" bson:"end"`
+	Recurrence      string             `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	RecurrenceUntil *time.Time         `json:"recurrence_until,omitempty" bson:"recurrence_until,omitempty"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OnCallSchedule rotates through users in fixed-length shifts starting at RotationStart
+type OnCallSchedule struct {
+	ID            primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Organization  string               `json:"organization" bson:"organization"`
+	Name          string               `json:"name" bson:"name"`
+	UserIDs       []primitive.ObjectID `json:"user_ids" bson:"user_ids"` // Rotation order
+	RotationStart time.Time            `json:"rotation_start" bson:"rotation_start"`
+	ShiftHours    int                  `json:"shift_hours" bson:"shift_hours"`
+	Overrides     []OnCallOverride     `json:"overrides" bson:"overrides"`
+	CreatedAt     time.Time            `json:"created_at" bson:"created_at"`
+}
+
+// OnCallOverride replaces the rotation with a specific user for a time range
+type OnCallOverride struct {
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Start  time.Time          `json:"start" bson:"start"`
+	End    time.Time          `json:"end" bson:"end"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rollup intervals
+const (
+	RollupHour = "hour"
+	RollupDay  = "day"
+)
+
+// RetentionPolicy sets how long each tier of vibration data is kept, for an
+// organization or a single sensor. A sensor policy takes precedence over its
+// organization's. A zero number of days keeps that tier forever.
+type RetentionPolicy struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Organization string             `json:"organization,omitempty" bson:"organization,omitempty"`
+	SerialNumber string             `json:"serial_number,omitempty" bson:"serial_number,omitempty"`
+	Name         string             `json:"name" bson:"name"`
+	RawDays      int                `json:"raw_days" bson:"raw_days"`       // Full readings with spectra
+	HourlyDays   int                `json:"hourly_days" bson:"hourly_days"` // Hourly rollups
+	DailyDays    int                `json:"daily_days" bson:"daily_days"`   // Daily rollups
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// VibrationRollup aggregates a sensor's readings over one hour or day. Rollups
+// are written before raw readings are deleted by retention.
+type VibrationRollup struct {
+	ID           primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	SerialNumber string                 `json:"serial_number" bson:"serial_number"`
+	Interval     string                 `json:"interval" bson:"interval"`
+	Time         time.Time              `json:"time" bson:"time"` // Start of the bucket
+	Count        int64                  `json:"count" bson:"count"`
+	Metrics      map[string]MetricStats `json:"metrics" bson:"metrics"`
+}
+
+type MetricStats struct {
+	Min  float64 `json:"min" bson:"min"`
+	Max  float64 `json:"max" bson:"max"`
+	Mean float64 `json:"mean" bson:"mean"`
+	P95  float64 `json:"p95" bson:"p95"`
+}
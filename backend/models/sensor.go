@@ -19,4 +19,28 @@ type Sensor struct {
 	AlarmThs     float64            `json:"alarm_ths" bson:"alarm_ths"`
 	Token        string             `json:"token,omitempty" bson:"token,omitempty"`
 	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+
+	// Machine data used for fault frequency analytics
+	RunningSpeedRPM float64          `json:"running_speed_rpm,omitempty" bson:"running_speed_rpm,omitempty"`
+	Bearing         *BearingGeometry `json:"bearing,omitempty" bson:"bearing,omitempty"`
+
+	// Anomaly score at which a reading raises a warning; 0 uses the server default
+	AnomalyThreshold float64 `json:"anomaly_threshold,omitempty" bson:"anomaly_threshold,omitempty"`
+
+	// Ingest limits for this sensor; unset fields use the server defaults
+	Quota *IngestQuota `json:"quota,omitempty" bson:"quota,omitempty"`
+}
+
+// IngestQuota limits how much a sensor may send
+type IngestQuota struct {
+	MaxReadingsPerMinute int   `json:"max_readings_per_minute,omitempty" bson:"max_readings_per_minute,omitempty"`
+	MaxPayloadBytes      int64 `json:"max_payload_bytes,omitempty" bson:"max_payload_bytes,omitempty"` // Largest decoded request body
+}
+
+// BearingGeometry describes a rolling element bearing. Diameters share any unit.
+type BearingGeometry struct {
+	Balls         int     `json:"balls" bson:"balls"`
+	BallDiameter  float64 `json:"ball_diameter" bson:"ball_diameter"`
+	PitchDiameter float64 `json:"pitch_diameter" bson:"pitch_diameter"`
+	ContactAngle  float64 `json:"contact_angle" bson:"contact_angle"` // Degrees
 }
@@ -10,9 +10,37 @@ type User struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Username     string             `json:"username" bson:"username"`
 	Email        string             `json:"email" bson:"email"`
+	Phone        string             `json:"phone" bson:"phone"`
 	Organization string             `json:"organization" bson:"organization"`
 	Password     string             `json:"password" bson:"password"`
 	Token        string             `json:"token,omitempty" bson:"token,omitempty"`
 	TokenExpiry  time.Time          `json:"token_expiry,omitempty" bson:"token_expiry,omitempty"`
 	RefreshToken string             `json:"refresh_token,omitempty" bson:"refresh_token,omitempty"`
+
+	// Notification settings for alarm delivery
+	NotificationPreferences NotificationPreferences `json:"notification_preferences" bson:"notification_preferences"`
+}
+
+// NotificationPreferences controls which channels a user is alerted on
+type NotificationPreferences struct {
+	EmailEnabled bool        `json:"email_enabled" bson:"email_enabled"`
+	SMSEnabled   bool        `json:"sms_enabled" bson:"sms_enabled"`
+	MinLevel     int         `json:"min_level" bson:"min_level"` // Lowest warning level that triggers a notification
+	QuietHours   *QuietHours `json:"quiet_hours,omitempty" bson:"quiet_hours,omitempty"`
+}
+
+// QuietHours is a daily window in which only Emergency notifications are sent.
+// Start and End use "HH:MM" format and the window may cross midnight.
+type QuietHours struct {
+	Start    string `json:"start" bson:"start"`
+	End      string `json:"end" bson:"end"`
+	Timezone string `json:"timezone" bson:"timezone"`
+}
+
+// StreamTicket lets a browser open a stream without putting its access token
+// in the URL. A ticket is used once and expires shortly after it is issued.
+type StreamTicket struct {
+	ID        string             `json:"ticket" bson:"_id"`
+	UserID    primitive.ObjectID `json:"-" bson:"user_id"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
 }
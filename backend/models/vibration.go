@@ -9,13 +9,37 @@ import (
 type VibrationData struct {
 	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	SerialNumber string             `bson:"serial_number" json:"serial_number"`
-	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+
+	// When the device took the reading; queries and aggregations use this
+	MeasuredAt time.Time `bson:"measured_at" json:"measured_at"`
+	// When the server received the reading
+	ReceivedAt time.Time `bson:"received_at" json:"received_at"`
+
+	// Set when the device time was outside the accepted skew window. A past
+	// device time is kept in MeasuredAt; a future one is replaced by the
+	// receive time and kept in DeviceTime.
+	ClockSkew  string     `bson:"clock_skew,omitempty" json:"clock_skew,omitempty"`
+	DeviceTime *time.Time `bson:"device_time,omitempty" json:"device_time,omitempty"`
+
+	// Same as MeasuredAt, kept for clients that predate it and as the time
+	// field of time-series collections. Accepted in place of measured_at.
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+
+	// Device-supplied ID used to drop retried submissions
+	MessageID string `bson:"message_id,omitempty" json:"message_id,omitempty"`
 
 	// FFT data for each axis
 	FFTX []float64 `bson:"fft_x" json:"fft_x"`
 	FFTY []float64 `bson:"fft_y" json:"fft_y"`
 	FFTZ []float64 `bson:"fft_z" json:"fft_z"`
 
+	// Set when the spectra and waveform are kept in object storage; the
+	// arrays above are then empty in the database
+	SpectraBlob *BlobRef `bson:"spectra_blob,omitempty" json:"spectra_blob,omitempty"`
+
+	// Snapshot of how the FFT bins were produced, taken at ingest
+	Spectral *SpectralMetadata `bson:"spectral,omitempty" json:"spectral,omitempty"`
+
 	// RMS values for each axis
 	RMSX float64 `bson:"rms_x" json:"rms_x"`
 	RMSY float64 `bson:"rms_y" json:"rms_y"`
@@ -25,4 +49,73 @@ type VibrationData struct {
 	PeakX float64 `bson:"peak_x" json:"peak_x"`
 	PeakY float64 `bson:"peak_y" json:"peak_y"`
 	PeakZ float64 `bson:"peak_z" json:"peak_z"`
+
+	// Derived values, set when the reading was computed from a raw waveform
+	CrestX    float64   `bson:"crest_x,omitempty" json:"crest_x,omitempty"`
+	CrestY    float64   `bson:"crest_y,omitempty" json:"crest_y,omitempty"`
+	CrestZ    float64   `bson:"crest_z,omitempty" json:"crest_z,omitempty"`
+	VelocityX []float64 `bson:"velocity_x,omitempty" json:"velocity_x,omitempty"` // Velocity spectrum in mm/s
+	VelocityY []float64 `bson:"velocity_y,omitempty" json:"velocity_y,omitempty"`
+	VelocityZ []float64 `bson:"velocity_z,omitempty" json:"velocity_z,omitempty"`
+	Waveform  *Waveform `bson:"waveform,omitempty" json:"waveform,omitempty"`
+
+	// Set by the server on readings it computed from a waveform, whose
+	// spectral metadata describes the computation rather than the sensor
+	FromWaveform bool `bson:"-" json:"-"`
+
+	// Deviation from the sensor's baseline, set when a baseline exists
+	Anomaly *AnomalyScore `bson:"anomaly,omitempty" json:"anomaly,omitempty"`
+
+	// Set when the reading arrived during a maintenance window
+	Suppressed          bool                `bson:"suppressed,omitempty" json:"suppressed,omitempty"`
+	MaintenanceWindowID *primitive.ObjectID `bson:"maintenance_window_id,omitempty" json:"maintenance_window_id,omitempty"`
+}
+
+// Clock skew of a device time outside the accepted window
+const (
+	ClockSkewFuture = "future"
+	ClockSkewPast   = "past"
+)
+
+// Waveform is a raw acceleration time waveform in g, sampled at SampleRate Hz
+type Waveform struct {
+	SampleRate float64   `bson:"sample_rate" json:"sample_rate"`
+	Window     string    `bson:"window" json:"window"` // Window applied before the FFT
+	X          []float64 `bson:"x" json:"x"`
+	Y          []float64 `bson:"y" json:"y"`
+	Z          []float64 `bson:"z" json:"z"`
+}
+
+// Spectrum amplitude units
+const (
+	UnitsG        = "g"
+	UnitsMMPerSec = "mm/s"
+	UnitsMPerSec2 = "m/s²"
+)
+
+// Spectrum amplitude scaling
+const (
+	ScalingPeak       = "peak"
+	ScalingRMS        = "rms"
+	ScalingPeakToPeak = "peak_to_peak"
+)
+
+// SpectralMetadata describes the frequency axis and amplitude convention of the
+// FFT arrays. Bin k is at k * BinWidth Hz.
+type SpectralMetadata struct {
+	FMax     float64 `bson:"fmax" json:"fmax"`
+	Lines    int     `bson:"lines" json:"lines"`
+	BinWidth float64 `bson:"bin_width" json:"bin_width"`
+	Units    string  `bson:"units" json:"units"`
+	Scaling  string  `bson:"scaling" json:"scaling"`
+	Window   string  `bson:"window,omitempty" json:"window,omitempty"`
+	Averages int     `bson:"averages,omitempty" json:"averages,omitempty"`
+}
+
+// BlobRef points to arrays offloaded to object storage
+type BlobRef struct {
+	Key      string `bson:"key" json:"key"`
+	Encoding string `bson:"encoding" json:"encoding"`
+	Size     int    `bson:"size" json:"size"`         // Stored (compressed) bytes
+	Checksum string `bson:"checksum" json:"checksum"` // SHA-256 of the stored bytes, hex
 }
@@ -0,0 +1,167 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/controllers"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Placeholder in topic patterns for the sensor serial number
+const serialPlaceholder = "{serial}"
+
+// message is the MQTT payload: the same document as POST /:apikey/vibrations
+// plus the API key of the sending sensor
+type message struct {
+	models.VibrationData
+	APIKey string `json:"api_key"`
+}
+
+// Bridge subscribes to sensor topics and feeds readings into the ingest pipeline
+type Bridge struct {
+	client  mqtt.Client
+	cfg     *config.Config
+	pattern []string
+}
+
+// Start connects to the broker and subscribes to the configured topic pattern.
+// The subscription is renewed on every reconnect.
+func Start(cfg *config.Config) (*Bridge, error) {
+	b := &Bridge{
+		cfg:     cfg,
+		pattern: strings.Split(cfg.MQTTTopicPattern, "/"),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.MQTTBrokerURL).
+		SetClientID(cfg.MQTTClientID).
+		SetUsername(cfg.MQTTUsername).
+		SetPassword(cfg.MQTTPassword).
+		SetAutoReconnect(true).
+		SetOrderMatters(false).
+		SetOnConnectHandler(b.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT error - Connection lost: %v", err)
+		})
+
+	b.client = mqtt.NewClient(opts)
+	token := b.client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", cfg.MQTTBrokerURL)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %v", err)
+	}
+
+	return b, nil
+}
+
+// Stop disconnects from the broker, giving messages being handled a moment
+// to finish
+func (b *Bridge) Stop() {
+	b.client.Disconnect(1000)
+}
+
+func (b *Bridge) subscribe(client mqtt.Client) {
+	filter := strings.ReplaceAll(b.cfg.MQTTTopicPattern, serialPlaceholder, "+")
+	token := client.Subscribe(filter, byte(b.cfg.MQTTQoS), b.handle)
+	if token.Wait() && token.Error() != nil {
+		log.Printf("MQTT error - Failed to subscribe to %s: %v", filter, token.Error())
+		return
+	}
+	log.Printf("Subscribed to MQTT topic %s", filter)
+}
+
+// handle ingests one message and publishes the outcome to the ack or error topic
+func (b *Bridge) handle(_ mqtt.Client, msg mqtt.Message) {
+	serial := b.topicSerial(msg.Topic())
+
+	var payload message
+	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
+		b.reply(b.cfg.MQTTErrorTopic, serial, map[string]interface{}{
+			"status": http.StatusBadRequest,
+			"error":  "invalid payload: " + err.Error(),
+		})
+		return
+	}
+
+	// The topic names the sensor; a serial in the payload must agree with it
+	vibration := payload.VibrationData
+	if serial != "" {
+		if vibration.SerialNumber != "" && vibration.SerialNumber != serial {
+			b.reply(b.cfg.MQTTErrorTopic, serial, map[string]interface{}{
+				"status": http.StatusBadRequest,
+				"error":  "Serial number does not match the topic",
+			})
+			return
+		}
+		vibration.SerialNumber = serial
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	queued, err := controllers.IngestWithAPIKey(ctx, payload.APIKey, &vibration)
+	if err != nil {
+		reply := map[string]interface{}{
+			"status": http.StatusInternalServerError,
+			"error":  err.Error(),
+		}
+		switch err := err.(type) {
+		case *controllers.IngestError:
+			reply["status"] = err.Status
+		case *controllers.RateLimitError:
+			reply["status"] = http.StatusTooManyRequests
+			reply["retry_after"] = err.RetryAfterSeconds()
+		}
+		b.reply(b.cfg.MQTTErrorTopic, serial, reply)
+		return
+	}
+
+	b.reply(b.cfg.MQTTAckTopic, vibration.SerialNumber, map[string]interface{}{
+		"id":            vibration.ID.Hex(),
+		"serial_number": vibration.SerialNumber,
+		"measured_at":   vibration.MeasuredAt,
+		"received_at":   vibration.ReceivedAt,
+		"queued":        queued,
+	})
+}
+
+// topicSerial extracts the serial number from a topic matching the pattern
+func (b *Bridge) topicSerial(topic string) string {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(b.pattern) {
+		return ""
+	}
+	for i, level := range b.pattern {
+		if level == serialPlaceholder {
+			return levels[i]
+		}
+	}
+	return ""
+}
+
+func (b *Bridge) reply(topicPattern, serial string, body map[string]interface{}) {
+	if topicPattern == "" {
+		return
+	}
+	if serial == "" && strings.Contains(topicPattern, serialPlaceholder) {
+		return
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+
+	topic := strings.ReplaceAll(topicPattern, serialPlaceholder, serial)
+	b.client.Publish(topic, byte(b.cfg.MQTTQoS), false, payload)
+}
@@ -0,0 +1,151 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/models"
+)
+
+// Level at which quiet hours are ignored
+const emergencyLevel = 4
+
+// Notifier routes alerts to users over the channels they have enabled
+type Notifier struct {
+	providers map[Channel]Provider
+}
+
+var defaultNotifier = &Notifier{providers: map[Channel]Provider{}}
+
+// Setup configures the default notifier from the application config.
+// A configured sink file replaces every real provider.
+func Setup(cfg *config.Config) {
+	providers := map[Channel]Provider{}
+
+	if cfg.NotificationSinkFile != "" {
+		sink := &FileProvider{Path: cfg.NotificationSinkFile}
+		providers[ChannelEmail] = sink
+		providers[ChannelSMS] = sink
+	} else {
+		if cfg.SMTPHost != "" {
+			providers[ChannelEmail] = &SMTPProvider{
+				Host:     cfg.SMTPHost,
+				Port:     cfg.SMTPPort,
+				Username: cfg.SMTPUsername,
+				Password: cfg.SMTPPassword,
+				From:     cfg.SMTPFrom,
+			}
+		}
+		if cfg.SMSGatewayURL != "" {
+			providers[ChannelSMS] = NewHTTPSMSProvider(cfg.SMSGatewayURL, cfg.SMSGatewayToken)
+		}
+	}
+
+	defaultNotifier = NewNotifier(providers)
+}
+
+// NewNotifier creates a notifier with the given channel providers
+func NewNotifier(providers map[Channel]Provider) *Notifier {
+	return &Notifier{providers: providers}
+}
+
+// Notify sends an alert to a user with the default notifier
+func Notify(ctx context.Context, user models.User, alert Alert) ([]Message, error) {
+	return defaultNotifier.Notify(ctx, user, alert)
+}
+
+// Notify sends an alert to every channel the user has enabled and returns the
+// messages that were delivered. Delivery errors on one channel do not stop the others.
+func (n *Notifier) Notify(ctx context.Context, user models.User, alert Alert) ([]Message, error) {
+	// Users stored before min_level was validated may have 0, which would let
+	// Normal readings through
+	prefs := user.NotificationPreferences
+	if alert.Level < max(prefs.MinLevel, 1) {
+		return nil, nil
+	}
+
+	if alert.Level < emergencyLevel && inQuietHours(prefs.QuietHours, alert.Time) {
+		return nil, nil
+	}
+
+	var targets []Message
+	if prefs.EmailEnabled && user.Email != "" {
+		targets = append(targets, Message{Channel: ChannelEmail, To: user.Email})
+	}
+	if prefs.SMSEnabled && user.Phone != "" {
+		targets = append(targets, Message{Channel: ChannelSMS, To: user.Phone})
+	}
+
+	var sent []Message
+	var errs []error
+	for _, msg := range targets {
+		provider, ok := n.providers[msg.Channel]
+		if !ok {
+			continue
+		}
+
+		subject, body, err := render(msg.Channel, alert)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		msg.Subject = subject
+		msg.Body = body
+		msg.Level = alert.Level
+		msg.SentAt = time.Now()
+
+		if err := provider.Send(ctx, msg); err != nil {
+			log.Printf("Notification error - %s to %s failed: %v", msg.Channel, msg.To, err)
+			errs = append(errs, fmt.Errorf("%s: %v", msg.Channel, err))
+			continue
+		}
+		sent = append(sent, msg)
+	}
+
+	return sent, errors.Join(errs...)
+}
+
+// inQuietHours reports whether t falls inside the user's quiet hours
+func inQuietHours(quiet *models.QuietHours, t time.Time) bool {
+	if quiet == nil || quiet.Start == "" || quiet.End == "" {
+		return false
+	}
+
+	loc := time.UTC
+	if quiet.Timezone != "" {
+		if l, err := time.LoadLocation(quiet.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	start, err := parseClock(quiet.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(quiet.End)
+	if err != nil {
+		return false
+	}
+
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+
+	if start <= end {
+		return now >= start && now < end
+	}
+	// Window crosses midnight
+	return now >= start || now < end
+}
+
+// parseClock converts "HH:MM" into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,29 @@
+package notifications
+
+import (
+	"context"
+	"time"
+)
+
+// Channel identifies how a notification is delivered
+type Channel string
+
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+)
+
+// Message is a rendered notification ready to be sent to one recipient
+type Message struct {
+	Channel Channel   `json:"channel"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject,omitempty"`
+	Body    string    `json:"body"`
+	Level   int       `json:"level"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Provider delivers messages over a single channel
+type Provider interface {
+	Send(ctx context.Context, msg Message) error
+}
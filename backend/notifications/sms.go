@@ -0,0 +1,56 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPSMSProvider posts SMS messages as JSON to a generic HTTP gateway.
+// The gateway receives {"to": "...", "message": "..."}.
+type HTTPSMSProvider struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func NewHTTPSMSProvider(url, token string) *HTTPSMSProvider {
+	return &HTTPSMSProvider{
+		URL:    url,
+		Token:  token,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *HTTPSMSProvider) Send(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(map[string]string{
+		"to":      msg.To,
+		"message": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SMS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS gateway returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// SMTPProvider sends email notifications through an SMTP relay
+type SMTPProvider struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (p *SMTPProvider) Send(ctx context.Context, msg Message) error {
+	addr := p.Host + ":" + strconv.Itoa(p.Port)
+
+	var auth smtp.Auth
+	if p.Username != "" {
+		auth = smtp.PlainAuth("", p.Username, p.Password, p.Host)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", p.From)
+	fmt.Fprintf(&body, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(msg.Subject)))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	// net/smtp has no context support, so run the send and honour cancellation
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, p.From, []string{msg.To}, []byte(body.String()))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// headerValue folds line breaks into spaces so that values taken from sensor
+// or user data cannot add header lines
+func headerValue(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+}
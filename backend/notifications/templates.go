@@ -0,0 +1,95 @@
+package notifications
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+)
+
+// Alert holds the values available to notification templates
+type Alert struct {
+	Level        int
+	LevelName    string
+	SerialNumber string
+	Location     string
+	RMSX         float64
+	RMSY         float64
+	RMSZ         float64
+	AlarmThs     float64
+	Time         time.Time
+}
+
+type levelTemplate struct {
+	subject *template.Template
+	email   *template.Template
+	sms     *template.Template
+}
+
+func mustLevelTemplate(subject, email, sms string) levelTemplate {
+	return levelTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		email:   template.Must(template.New("email").Parse(email)),
+		sms:     template.Must(template.New("sms").Parse(sms)),
+	}
+}
+
+const emailDetails = `Sensor:   {{.SerialNumber}}
+Location: {{.Location}}
+Time:     {{.Time.Format "2006-01-02 15:04:05 MST"}}
+
+RMS X: {{printf "%.3f" .RMSX}}
+RMS Y: {{printf "%.3f" .RMSY}}
+RMS Z: {{printf "%.3f" .RMSZ}}
+Alarm threshold: {{printf "%.3f" .AlarmThs}}
+`
+
+// Templates per warning level
+// Level 1: Normal
+// Level 2: Warning
+// Level 3: Critical
+// Level 4: Emergency
+var levelTemplates = map[int]levelTemplate{
+	1: mustLevelTemplate(
+		`[Normal] {{.SerialNumber}} back to normal`,
+		"Vibration on sensor {{.SerialNumber}} has returned to normal.\n\n"+emailDetails,
+		`{{.SerialNumber}} ({{.Location}}) back to normal`,
+	),
+	2: mustLevelTemplate(
+		`[Warning] Elevated vibration on {{.SerialNumber}}`,
+		"Vibration on sensor {{.SerialNumber}} is elevated. Please schedule an inspection.\n\n"+emailDetails,
+		`WARNING {{.SerialNumber}} ({{.Location}}): elevated vibration`,
+	),
+	3: mustLevelTemplate(
+		`[Critical] High vibration on {{.SerialNumber}}`,
+		"Vibration on sensor {{.SerialNumber}} is close to its alarm threshold. Inspect as soon as possible.\n\n"+emailDetails,
+		`CRITICAL {{.SerialNumber}} ({{.Location}}): high vibration, inspect ASAP`,
+	),
+	4: mustLevelTemplate(
+		`[EMERGENCY] Alarm threshold exceeded on {{.SerialNumber}}`,
+		"Vibration on sensor {{.SerialNumber}} has exceeded its alarm threshold. Immediate action required.\n\n"+emailDetails,
+		`EMERGENCY {{.SerialNumber}} ({{.Location}}): alarm threshold exceeded, act now`,
+	),
+}
+
+// render builds the subject and body for a channel from the level template
+func render(channel Channel, alert Alert) (string, string, error) {
+	tmpl, ok := levelTemplates[alert.Level]
+	if !ok {
+		tmpl = levelTemplates[4]
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, alert); err != nil {
+		return "", "", err
+	}
+
+	bodyTemplate := tmpl.email
+	if channel == ChannelSMS {
+		bodyTemplate = tmpl.sms
+	}
+	if err := bodyTemplate.Execute(&body, alert); err != nil {
+		return "", "", err
+	}
+
+	return subject.String(), body.String(), nil
+}
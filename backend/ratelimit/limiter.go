@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ThirawatEu/vibration-sensor-gas-pipe/config"
+)
+
+// Limit is a token bucket that holds up to Burst tokens and refills at Rate
+// tokens per second. A zero Rate means no limit.
+type Limit struct {
+	Rate  float64
+	Burst float64
+}
+
+// PerMinute returns a limit of n tokens a minute whose bucket holds burst
+// worth of tokens, but at least one
+func PerMinute(n int, burst time.Duration) Limit {
+	if n <= 0 {
+		return Limit{}
+	}
+	rate := float64(n) / 60
+	capacity := rate * burst.Seconds()
+	if capacity < 1 {
+		capacity = 1
+	}
+	return Limit{Rate: rate, Burst: capacity}
+}
+
+// Unlimited reports whether the limit lets everything through
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// Result is the outcome of taking tokens from a bucket
+type Result struct {
+	Allowed    bool
+	Remaining  float64
+	RetryAfter time.Duration // Until enough tokens are back, when not allowed
+}
+
+// Usage counts the tokens a bucket let through and turned away
+type Usage struct {
+	Allowed   int64     `json:"allowed" bson:"allowed"`
+	Rejected  int64     `json:"rejected" bson:"rejected"`
+	Tokens    float64   `json:"tokens" bson:"tokens"` // Left at the last update
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at"`
+}
+
+// Limiter keeps token buckets by key
+type Limiter interface {
+	// Take removes n tokens from the key's bucket if it holds that many
+	Take(ctx context.Context, key string, limit Limit, n int) (Result, error)
+
+	// Refund puts back n tokens taken from the key's bucket, for readings
+	// that a later check turned away
+	Refund(ctx context.Context, key string, limit Limit, n int) error
+
+	// Usage returns the key's counters; unknown keys have none
+	Usage(ctx context.Context, key string) (Usage, error)
+}
+
+var defaultLimiter Limiter = NewMemoryLimiter()
+
+// Setup configures the default limiter from the application config: "memory"
+// keeps buckets in this process and "mongo" shares them between instances
+func Setup(cfg *config.Config) error {
+	switch cfg.RateLimitBackend {
+	case "", "memory":
+		defaultLimiter = NewMemoryLimiter()
+	case "mongo":
+		defaultLimiter = NewMongoLimiter(config.GetCollection("rate_limits"))
+	default:
+		return fmt.Errorf("unknown rate limit backend %q, expected memory or mongo", cfg.RateLimitBackend)
+	}
+	return nil
+}
+
+// Default returns the configured limiter
+func Default() Limiter {
+	return defaultLimiter
+}
+
+// retryAfter returns how long a bucket with tokens left takes to refill to n
+func retryAfter(limit Limit, tokens float64, n int) time.Duration {
+	missing := float64(n) - tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / limit.Rate * float64(time.Second))
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter keeps buckets in this process. Each instance of a
+// multi-instance deployment then enforces its own limits.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*Usage
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: map[string]*Usage{}}
+}
+
+func (m *MemoryLimiter) Take(ctx context.Context, key string, limit Limit, n int) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &Usage{Tokens: limit.Burst, UpdatedAt: now}
+		m.buckets[key] = bucket
+	}
+	elapsed := math.Max(now.Sub(bucket.UpdatedAt).Seconds(), 0)
+	bucket.Tokens = math.Min(limit.Burst, bucket.Tokens+elapsed*limit.Rate)
+	bucket.UpdatedAt = now
+
+	if bucket.Tokens < float64(n) {
+		bucket.Rejected += int64(n)
+		return Result{Remaining: bucket.Tokens, RetryAfter: retryAfter(limit, bucket.Tokens, n)}, nil
+	}
+	bucket.Tokens -= float64(n)
+	bucket.Allowed += int64(n)
+	return Result{Allowed: true, Remaining: bucket.Tokens}, nil
+}
+
+func (m *MemoryLimiter) Refund(ctx context.Context, key string, limit Limit, n int) error {
+	if limit.Unlimited() {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if bucket, ok := m.buckets[key]; ok {
+		bucket.Tokens = math.Min(limit.Burst, bucket.Tokens+float64(n))
+		bucket.Allowed -= int64(n)
+	}
+	return nil
+}
+
+func (m *MemoryLimiter) Usage(ctx context.Context, key string) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if bucket, ok := m.buckets[key]; ok {
+		return *bucket, nil
+	}
+	return Usage{}, nil
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoLimiter keeps buckets in a collection shared by every instance, one
+// document per key. Each take is a single atomic pipeline update (MongoDB
+// 4.2+) that refills the bucket by the database clock, so instances need not
+// agree on the time.
+type MongoLimiter struct {
+	collection *mongo.Collection
+}
+
+func NewMongoLimiter(collection *mongo.Collection) *MongoLimiter {
+	return &MongoLimiter{collection: collection}
+}
+
+type mongoBucket struct {
+	Usage `bson:",inline"`
+	Taken bool `bson:"taken"`
+}
+
+func (m *MongoLimiter) Take(ctx context.Context, key string, limit Limit, n int) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+
+	elapsed := bson.M{"$max": bson.A{0, bson.M{"$divide": bson.A{
+		bson.M{"$subtract": bson.A{"$$NOW", bson.M{"$ifNull": bson.A{"$updated_at", "$$NOW"}}}}, 1000}}}}
+	refilled := bson.M{"$min": bson.A{limit.Burst, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", limit.Burst}},
+		bson.M{"$multiply": bson.A{elapsed, limit.Rate}},
+	}}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updated_at": "$$NOW"}}},
+		{{Key: "$set", Value: bson.M{"taken": bson.M{"$gte": bson.A{"$tokens", n}}}}},
+		{{Key: "$set", Value: bson.M{
+			"tokens":   bson.M{"$cond": bson.A{"$taken", bson.M{"$subtract": bson.A{"$tokens", n}}, "$tokens"}},
+			"allowed":  bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$allowed", 0}}, bson.M{"$cond": bson.A{"$taken", n, 0}}}},
+			"rejected": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rejected", 0}}, bson.M{"$cond": bson.A{"$taken", 0, n}}}},
+		}}},
+	}
+
+	var bucket mongoBucket
+	err := m.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&bucket)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if !bucket.Taken {
+		return Result{Remaining: bucket.Tokens, RetryAfter: retryAfter(limit, bucket.Tokens, n)}, nil
+	}
+	return Result{Allowed: true, Remaining: bucket.Tokens}, nil
+}
+
+func (m *MongoLimiter) Refund(ctx context.Context, key string, limit Limit, n int) error {
+	if limit.Unlimited() {
+		return nil
+	}
+
+	_, err := m.collection.UpdateOne(ctx, bson.M{"_id": key}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens":  bson.M{"$min": bson.A{limit.Burst, bson.M{"$add": bson.A{"$tokens", n}}}},
+			"allowed": bson.M{"$subtract": bson.A{"$allowed", n}},
+		}}},
+	})
+	return err
+}
+
+func (m *MongoLimiter) Usage(ctx context.Context, key string) (Usage, error) {
+	var bucket mongoBucket
+	err := m.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&bucket)
+	if err == mongo.ErrNoDocuments {
+		return Usage{}, nil
+	}
+	return bucket.Usage, err
+}
@@ -0,0 +1,167 @@
+package realtime
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types pushed to stream subscribers
+const (
+	EventVibration = "vibration"
+	EventAlarm     = "alarm"
+)
+
+const (
+	historySize      = 1024 // Events kept for clients resuming with a last event ID
+	subscriberBuffer = 64   // Events buffered per subscriber before it is considered slow
+)
+
+// Event is a single message on the stream
+type Event struct {
+	ID           uint64      `json:"id"`
+	Type         string      `json:"type"`
+	SerialNumber string      `json:"serial_number"`
+	Location     string      `json:"location,omitempty"`
+	Time         time.Time   `json:"time"`
+	Data         interface{} `json:"data"`
+}
+
+// Filter limits which events a subscriber receives. Empty fields match everything.
+type Filter struct {
+	SerialNumbers []string
+	Location      string
+}
+
+func (f Filter) matches(e Event) bool {
+	if f.Location != "" && f.Location != e.Location {
+		return false
+	}
+	if len(f.SerialNumbers) == 0 {
+		return true
+	}
+	for _, serial := range f.SerialNumbers {
+		if serial == e.SerialNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscriber receives matching events on C. C is closed when the subscriber
+// is removed, either by Unsubscribe or because it fell too far behind.
+type Subscriber struct {
+	C      chan Event
+	filter Filter
+	slow   bool
+}
+
+// Slow reports whether the subscriber was dropped for not keeping up.
+// It is only meaningful after C has been closed.
+func (s *Subscriber) Slow() bool {
+	return s.slow
+}
+
+// Hub fans out published events to subscribers in-process
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscriber]struct{}
+	history     []Event
+	lastID      uint64
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: map[*Subscriber]struct{}{}}
+}
+
+var defaultHub = NewHub()
+
+// Publish sends an event to the default hub
+func Publish(eventType, serialNumber, location string, data interface{}) {
+	defaultHub.Publish(eventType, serialNumber, location, data)
+}
+
+// Subscribe registers a subscriber on the default hub
+func Subscribe(filter Filter, lastEventID uint64) (*Subscriber, []Event, bool) {
+	return defaultHub.Subscribe(filter, lastEventID)
+}
+
+// Unsubscribe removes a subscriber from the default hub
+func Unsubscribe(sub *Subscriber) {
+	defaultHub.Unsubscribe(sub)
+}
+
+// Publish assigns the event an ID, records it for resuming clients and
+// delivers it to every matching subscriber without blocking. Subscribers
+// whose buffer is full are disconnected and can resume from their last event ID.
+func (h *Hub) Publish(eventType, serialNumber, location string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	event := Event{
+		ID:           h.lastID,
+		Type:         eventType,
+		SerialNumber: serialNumber,
+		Location:     location,
+		Time:         time.Now(),
+		Data:         data,
+	}
+
+	h.history = append(h.history, event)
+	if len(h.history) > historySize {
+		h.history = h.history[len(h.history)-historySize:]
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+			sub.slow = true
+			h.remove(sub)
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. When lastEventID is non-zero the
+// matching events published after it are returned as a backlog; complete is
+// false if some of those events are no longer in the history.
+func (h *Hub) Subscribe(filter Filter, lastEventID uint64) (*Subscriber, []Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscriber{
+		C:      make(chan Event, subscriberBuffer),
+		filter: filter,
+	}
+	h.subscribers[sub] = struct{}{}
+
+	if lastEventID == 0 {
+		return sub, nil, true
+	}
+
+	complete := len(h.history) == 0 || h.history[0].ID <= lastEventID+1
+	var backlog []Event
+	for _, event := range h.history {
+		if event.ID > lastEventID && filter.matches(event) {
+			backlog = append(backlog, event)
+		}
+	}
+	return sub, backlog, complete
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+func (h *Hub) remove(sub *Subscriber) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.C)
+	}
+}